@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// upcomingDays is the number of days (including today) shown on the
+// upcoming page.
+const upcomingDays = 14
+
+// agendaGroup is a heading on an agenda page and the items under it.
+type agendaGroup struct {
+	Title string // may be empty for pages with a single group
+	Items []*ListItem
+}
+
+// agendaPage is the template data for an agenda page (including the tag
+// page, which uses the same layout).
+type agendaPage struct {
+	Token  string
+	Title  string
+	Return string // path to return to after checking off an item
+	Empty  string // message to show if there are no items
+	Groups []*agendaGroup
+}
+
+func (s *Server) showToday(w http.ResponseWriter, r *http.Request) {
+	s.showAgenda(w, r, "Today", "/today", "Nothing due today.", 1)
+}
+
+func (s *Server) showUpcoming(w http.ResponseWriter, r *http.Request) {
+	s.showAgenda(w, r, "Upcoming", "/upcoming", "Nothing due in the next two weeks.", upcomingDays)
+}
+
+// showAgenda shows the undone items across all lists that are overdue or due
+// in the given number of days (starting today), grouped by day.
+func (s *Server) showAgenda(w http.ResponseWriter, r *http.Request, title, path, empty string, days int) {
+	if !s.showLists {
+		// Agenda shows items from every list, so it's only available if
+		// the home page shows the lists
+		http.NotFound(w, r)
+		return
+	}
+	now := s.now().In(s.location)
+	year, month, day := now.Date()
+	today := time.Date(year, month, day, 0, 0, 0, 0, s.location)
+	items, err := s.model.GetDueItems(today.AddDate(0, 0, days))
+	if err != nil {
+		s.internalError(w, r, "fetching due items", err)
+		return
+	}
+
+	// Overdue items first (a timed item due earlier today can be overdue
+	// while an all-day item due today isn't), then by day
+	overdue := &agendaGroup{Title: "Overdue"}
+	var groups []*agendaGroup
+	var group *agendaGroup
+	for _, item := range items {
+		// Change UTC timezone to display timezone
+		item.TimeDue = item.TimeDue.In(s.location)
+		if s.dueClass(item.Item) == "overdue" {
+			overdue.Items = append(overdue.Items, item)
+			continue
+		}
+		groupTitle := dayTitle(item.TimeDue, today)
+		if group == nil || group.Title != groupTitle {
+			group = &agendaGroup{Title: groupTitle}
+			groups = append(groups, group)
+		}
+		group.Items = append(group.Items, item)
+	}
+	if len(overdue.Items) > 0 {
+		groups = append([]*agendaGroup{overdue}, groups...)
+	}
+
+	page := &agendaPage{
+		Token:  s.getCSRFToken(w, r),
+		Title:  title,
+		Return: path,
+		Empty:  empty,
+		Groups: groups,
+	}
+	err = s.agendaTmpl.Execute(w, page)
+	if err != nil {
+		s.internalError(w, r, "rendering template", err)
+		return
+	}
+}
+
+// dayTitle returns the agenda heading for the day t is on, relative to the
+// start of today.
+func dayTitle(t, today time.Time) string {
+	switch {
+	case sameDate(today, t):
+		return "Today"
+	case sameDate(today.AddDate(0, 0, 1), t):
+		return "Tomorrow"
+	default:
+		return t.Format("Monday 2 January")
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAgenda(t *testing.T) {
+	server, model := newTestServer(t, nullLogger{}, Config{Lists: true, Timezone: "Pacific/Auckland"})
+	now := time.Date(2021, 9, 10, 12, 0, 0, 0, server.location) // a Friday
+	server.now = func() time.Time { return now }
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("creating cookie jar: %v", err)
+	}
+
+	addItem := func(listID, description, due string, allDay bool) string {
+		t.Helper()
+		item := &Item{Description: description, DueAllDay: allDay}
+		if due != "" {
+			timeDue, err := time.ParseInLocation("2006-01-02 15:04", due, server.location)
+			if err != nil {
+				t.Fatalf("parsing due date: %v", err)
+			}
+			item.TimeDue = timeDue
+		}
+		err := model.AddItems(listID, []*Item{item})
+		if err != nil {
+			t.Fatalf("adding item: %v", err)
+		}
+		list, err := model.GetList(listID)
+		if err != nil {
+			t.Fatalf("fetching list: %v", err)
+		}
+		return list.Items[len(list.Items)-1].ID
+	}
+	chores, err := model.CreateList("Chores")
+	if err != nil {
+		t.Fatalf("creating list: %v", err)
+	}
+	work, err := model.CreateList("Work")
+	if err != nil {
+		t.Fatalf("creating list: %v", err)
+	}
+	deleted, err := model.CreateList("Deleted")
+	if err != nil {
+		t.Fatalf("creating list: %v", err)
+	}
+	addItem(chores, "No due date", "", false)
+	addItem(chores, "Pay rent", "2021-09-08 00:00", true)
+	addItem(work, "Standup", "2021-09-10 09:00", false)
+	addItem(chores, "Water plants", "2021-09-10 00:00", true)
+	laundryID := addItem(chores, "Laundry", "2021-09-10 18:00", false)
+	addItem(work, "Release", "2021-09-11 10:00", false)
+	addItem(work, "Retro", "2021-09-23 00:00", true)
+	addItem(work, "Too far", "2021-09-24 00:00", true)
+	doneID := addItem(chores, "Already done", "2021-09-10 00:00", true)
+	err = model.UpdateDone(chores, doneID, true, now)
+	if err != nil {
+		t.Fatalf("updating done: %v", err)
+	}
+	addItem(deleted, "In deleted list", "2021-09-10 00:00", true)
+	err = model.DeleteList(deleted)
+	if err != nil {
+		t.Fatalf("deleting list: %v", err)
+	}
+
+	recorder := serve(t, server, jar, "GET", "/today", nil)
+	ensureCode(t, recorder, http.StatusOK)
+	ensureString(t, agendaSummary(recorder.Body.String()),
+		"Overdue: Pay rent (Chores), Standup (Work); Today: Water plants (Chores), Laundry (Chores)")
+
+	recorder = serve(t, server, jar, "GET", "/upcoming", nil)
+	ensureCode(t, recorder, http.StatusOK)
+	ensureString(t, agendaSummary(recorder.Body.String()),
+		"Overdue: Pay rent (Chores), Standup (Work); Today: Water plants (Chores), Laundry (Chores); "+
+			"Tomorrow: Release (Work); Thursday 23 September: Retro (Work)")
+
+	// Checking off an item returns to the agenda
+	forms := parseForms(t, recorder.Body.String())
+	ensureString(t, forms[3].Action, "/update-done")
+	ensureString(t, forms[3].Inputs["list-id"], chores)
+	ensureString(t, forms[3].Inputs["item-id"], laundryID)
+	ensureString(t, forms[3].Inputs["return"], "/upcoming")
+	form := url.Values{}
+	for name, value := range forms[3].Inputs {
+		form.Set(name, value)
+	}
+	recorder = serve(t, server, jar, "POST", "/update-done", form)
+	ensureRedirect(t, recorder, http.StatusFound, "/upcoming")
+	form.Set("return", "https://evil.example.com/")
+	recorder = serve(t, server, jar, "POST", "/update-done", form)
+	ensureRedirect(t, recorder, http.StatusFound, "/lists/"+chores)
+
+	recorder = serve(t, server, jar, "GET", "/today", nil)
+	ensureString(t, agendaSummary(recorder.Body.String()),
+		"Overdue: Pay rent (Chores), Standup (Work); Today: Water plants (Chores)")
+
+	// Agenda isn't available if lists aren't shown on the home page
+	hidden, _ := newTestServer(t, nullLogger{}, Config{})
+	recorder = serve(t, hidden, jar, "GET", "/today", nil)
+	ensureCode(t, recorder, http.StatusNotFound)
+}
+
+var agendaRegex = regexp.MustCompile(`<h2>([^<]*)</h2>|<label[^>]*>([^<]*)</label>|<a class="aside"[^>]*>([^<]*)</a>`)
+
+// agendaSummary returns a one-line summary of an agenda page's groups and
+// items, like "Today: Description (List), ...; Tomorrow: ...".
+func agendaSummary(body string) string {
+	var summary strings.Builder
+	for _, match := range agendaRegex.FindAllStringSubmatch(body, -1) {
+		switch {
+		case match[1] != "":
+			if summary.Len() > 0 {
+				summary.WriteString("; ")
+			}
+			summary.WriteString(match[1] + ":")
+		case match[2] != "":
+			if !strings.HasSuffix(summary.String(), ":") {
+				summary.WriteString(",")
+			}
+			summary.WriteString(" " + match[2])
+		default:
+			summary.WriteString(" (" + match[3] + ")")
+		}
+	}
+	return summary.String()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxBulkItems is the maximum number of items that can be added at once.
+const maxBulkItems = 500
+
+// bulletRegex matches a leading bullet like "-", "*", "•", "[ ]", or "[x]"
+// (or a combination, like "- [ ]").
+var bulletRegex = regexp.MustCompile(`^(?:[-*•](?:\s+|$)|\[[ xX]?\]\s*)+`)
+
+// parseBulkItems returns an item (or section heading) for each non-empty line
+// of text, optionally stripping any leading bullets.
+func parseBulkItems(text string, stripBullets bool) []*Item {
+	var items []*Item
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if stripBullets {
+			line = strings.TrimSpace(bulletRegex.ReplaceAllString(line, ""))
+		}
+		if line == "" {
+			continue
+		}
+		if name, ok := parseHeading(line); ok {
+			items = append(items, &Item{Description: name, Heading: true})
+		} else {
+			items = append(items, &Item{Description: line})
+		}
+	}
+	return items
+}
+
+func (s *Server) addItems(w http.ResponseWriter, r *http.Request) {
+	listID := r.FormValue("list-id")
+	list, err := s.model.GetList(listID)
+	if err != nil {
+		s.internalError(w, r, "fetching list", err)
+		return
+	}
+	if list == nil {
+		http.NotFound(w, r)
+		return
+	}
+	items := parseBulkItems(r.FormValue("descriptions"), r.FormValue("strip-bullets") == "on")
+	if len(items) > maxBulkItems {
+		s.errorPage(w, r, http.StatusBadRequest, "Too many items",
+			"The items weren't added because you can only add "+
+				strconv.Itoa(maxBulkItems)+" items at once.")
+		return
+	}
+	if len(items) > 0 {
+		err = s.model.AddItems(list.ID, items)
+		if err != nil {
+			s.internalError(w, r, "adding items", err)
+			return
+		}
+	}
+	s.redirect(w, r, "/lists/"+list.ID)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseBulkItems(t *testing.T) {
+	text := "- Milk\r\n\r\n  * Eggs  \n[ ] Bread\n- [x] Jam\n• Tea\n-5 degree freezer\n## Produce\n  \n*\nApples"
+	tests := []struct {
+		strip bool
+		want  string
+	}{
+		{true, "Milk|Eggs|Bread|Jam|Tea|-5 degree freezer|## Produce|Apples"},
+		{false, "- Milk|* Eggs|[ ] Bread|- [x] Jam|• Tea|-5 degree freezer|## Produce|*|Apples"},
+	}
+	for _, test := range tests {
+		var lines []string
+		for _, item := range parseBulkItems(text, test.strip) {
+			if item.Heading {
+				lines = append(lines, "## "+item.Description)
+			} else {
+				lines = append(lines, item.Description)
+			}
+		}
+		ensureString(t, strings.Join(lines, "|"), test.want)
+	}
+}
+
+func TestAddItems(t *testing.T) {
+	server, model := newTestServer(t, nullLogger{}, Config{})
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("creating cookie jar: %v", err)
+	}
+	listID, err := model.CreateList("Shopping")
+	if err != nil {
+		t.Fatalf("creating list: %v", err)
+	}
+	err = model.AddItems(listID, []*Item{{Description: "Bags"}})
+	if err != nil {
+		t.Fatalf("adding items: %v", err)
+	}
+
+	recorder := serve(t, server, jar, "GET", "/lists/"+listID+"?bulk=1", nil)
+	ensureCode(t, recorder, http.StatusOK)
+	forms := parseForms(t, recorder.Body.String())
+	ensureString(t, forms[0].Action, "/add-items")
+	ensureString(t, forms[0].Inputs["strip-bullets"], "on")
+
+	form := url.Values{}
+	form.Set("csrf-token", forms[0].Inputs["csrf-token"])
+	form.Set("list-id", listID)
+	form.Set("descriptions", "- Milk\r\n\r\n- Screws #hardware\r\n## Produce\r\n- Apples\r\n")
+	form.Set("strip-bullets", "on")
+	recorder = serve(t, server, jar, "POST", "/add-items", form)
+	ensureRedirect(t, recorder, http.StatusFound, "/lists/"+listID)
+
+	list, err := model.GetList(listID)
+	if err != nil {
+		t.Fatalf("fetching list: %v", err)
+	}
+	var names []string
+	for _, item := range list.Items {
+		names = append(names, item.Description)
+	}
+	ensureString(t, strings.Join(names, ", "), "Bags, Milk, Screws #hardware, Produce, Apples")
+	if !list.Items[3].Heading {
+		t.Fatalf("expected heading: %+v", list.Items[3])
+	}
+	tagged, err := model.GetTagItems("hardware")
+	if err != nil {
+		t.Fatalf("fetching tagged items: %v", err)
+	}
+	ensureInt(t, len(tagged), 1)
+
+	// Too many items adds none of them
+	form.Set("descriptions", strings.Repeat("Item\n", maxBulkItems+1))
+	recorder = serve(t, server, jar, "POST", "/add-items", form)
+	ensureCode(t, recorder, http.StatusBadRequest)
+	list, err = model.GetList(listID)
+	if err != nil {
+		t.Fatalf("fetching list: %v", err)
+	}
+	ensureInt(t, len(list.Items), 5)
+
+	form.Set("list-id", "nosuchlist")
+	form.Set("descriptions", "Item")
+	recorder = serve(t, server, jar, "POST", "/add-items", form)
+	ensureCode(t, recorder, http.StatusNotFound)
+}
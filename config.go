@@ -0,0 +1,652 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the application's configuration. Each field can be set via an
+// environment variable, a key in the JSON config file, or a command-line
+// flag (see settings).
+type Config struct {
+	Port           int    `json:"port"`
+	UnixSocket     string `json:"unix_socket"`
+	UnixSocketMode string `json:"unix_socket_mode"`
+	SystemdSocket  bool   `json:"systemd_socket"`
+	DB             string `json:"db"`
+	Lists          bool   `json:"lists"`
+	BasePath       string `json:"base_path"`
+	PublicURL      string `json:"public_url"`
+	Timezone       string `json:"timezone"`
+	Username       string `json:"username"`
+	PassHash       string `json:"passhash"`
+	TOTPSecret     string `json:"totp_secret"`
+	TOTPRecovery   string `json:"totp_recovery"`
+	AuthHeader     string `json:"auth_header"`
+	TrustedProxies string `json:"trusted_proxies"`
+
+	OIDCIssuer         string `json:"oidc_issuer"`
+	OIDCClientID       string `json:"oidc_client_id"`
+	OIDCClientSecret   string `json:"oidc_client_secret"`
+	OIDCRedirectURL    string `json:"oidc_redirect_url"`
+	OIDCAllowedDomains string `json:"oidc_allowed_domains"`
+
+	SessionLifetime      string `json:"session_lifetime"`
+	SessionIdleTimeout   string `json:"session_idle_timeout"`
+	SessionRenewInterval string `json:"session_renew_interval"`
+
+	ContentSecurityPolicy string `json:"content_security_policy"`
+	FrameOptions          string `json:"frame_options"`
+	ReferrerPolicy        string `json:"referrer_policy"`
+	PermissionsPolicy     string `json:"permissions_policy"`
+	HSTSMaxAge            string `json:"hsts_max_age"`
+
+	CSRFSecret   string `json:"csrf_secret"`
+	MetricsToken string `json:"metrics_token"`
+	LogFormat    string `json:"log_format"`
+	LogLevel     string `json:"log_level"`
+}
+
+// DefaultConfig returns the configuration defaults.
+func DefaultConfig() Config {
+	return Config{
+		Port:           8080,
+		UnixSocketMode: "0660",
+		DB:             "simplelists.sqlite",
+		LogFormat:      LogFormatLogfmt,
+		LogLevel:       LevelInfo.String(),
+
+		ContentSecurityPolicy: defaultContentSecurityPolicy,
+		FrameOptions:          "DENY",
+		ReferrerPolicy:        "same-origin",
+		PermissionsPolicy:     defaultPermissionsPolicy,
+	}
+}
+
+// setting describes a single configuration setting.
+type setting struct {
+	key    string // config file key, also used (with - instead of _) as flag name
+	env    string // environment variable name
+	usage  string
+	secret bool // redacted when printing config
+	isBool bool
+	get    func(c *Config) string
+	set    func(c *Config, s string) error
+	setEnv func(c *Config, s string) error // if not nil, used instead of set for the environment variable
+}
+
+var settings = []*setting{
+	{
+		key:   "port",
+		env:   "PORT",
+		usage: "HTTP port to listen on",
+		get:   func(c *Config) string { return strconv.Itoa(c.Port) },
+		set:   func(c *Config, s string) error { return setInt(&c.Port, s) },
+	},
+	{
+		key:   "unix_socket",
+		env:   "SIMPLELISTS_UNIX_SOCKET",
+		usage: "path of Unix domain socket to listen on (instead of port)",
+		get:   func(c *Config) string { return c.UnixSocket },
+		set:   func(c *Config, s string) error { c.UnixSocket = s; return nil },
+	},
+	{
+		key:   "unix_socket_mode",
+		env:   "SIMPLELISTS_UNIX_SOCKET_MODE",
+		usage: "permissions of Unix domain socket (octal)",
+		get:   func(c *Config) string { return c.UnixSocketMode },
+		set:   func(c *Config, s string) error { c.UnixSocketMode = s; return nil },
+	},
+	{
+		key:    "systemd_socket",
+		env:    "SIMPLELISTS_SYSTEMD_SOCKET",
+		usage:  "listen on socket passed by systemd socket activation",
+		isBool: true,
+		get:    func(c *Config) string { return strconv.FormatBool(c.SystemdSocket) },
+		set:    func(c *Config, s string) error { return setBool(&c.SystemdSocket, s) },
+	},
+	{
+		key:   "db",
+		env:   "SIMPLELISTS_DB",
+		usage: "path to SQLite 3 database",
+		get:   func(c *Config) string { return c.DB },
+		set:   func(c *Config, s string) error { c.DB = s; return nil },
+	},
+	{
+		key:    "lists",
+		env:    "SIMPLELISTS_LISTS",
+		usage:  "show lists on homepage",
+		isBool: true,
+		get:    func(c *Config) string { return strconv.FormatBool(c.Lists) },
+		set:    func(c *Config, s string) error { return setBool(&c.Lists, s) },
+		setEnv: func(c *Config, s string) error {
+			// As before there were config files: lists are only shown if set
+			// to 1 or "true", any other value (including empty) means false
+			c.Lists = s == "1" || s == "true"
+			return nil
+		},
+	},
+	{
+		key:   "base_path",
+		env:   "SIMPLELISTS_BASE_PATH",
+		usage: "URL path prefix to serve under, for example /lists-app",
+		get:   func(c *Config) string { return c.BasePath },
+		set:   func(c *Config, s string) error { c.BasePath = s; return nil },
+	},
+	{
+		key:   "public_url",
+		env:   "SIMPLELISTS_PUBLIC_URL",
+		usage: "URL the site is served at, for example https://example.com/lists-app (forms are only accepted from this origin; defaults to the request's Host)",
+		get:   func(c *Config) string { return c.PublicURL },
+		set:   func(c *Config, s string) error { c.PublicURL = s; return nil },
+	},
+	{
+		key:   "timezone",
+		env:   "SIMPLELISTS_TIMEZONE",
+		usage: "IANA timezone name (defaults to local timezone)",
+		get:   func(c *Config) string { return c.Timezone },
+		set:   func(c *Config, s string) error { c.Timezone = s; return nil },
+	},
+	{
+		key:   "username",
+		env:   "SIMPLELISTS_USERNAME",
+		usage: "optional username to access site",
+		get:   func(c *Config) string { return c.Username },
+		set:   func(c *Config, s string) error { c.Username = s; return nil },
+	},
+	{
+		key:    "passhash",
+		env:    "SIMPLELISTS_PASSHASH",
+		usage:  "password hash (required if username is set)",
+		secret: true,
+		get:    func(c *Config) string { return c.PassHash },
+		set:    func(c *Config, s string) error { c.PassHash = s; return nil },
+	},
+	{
+		key:    "totp_secret",
+		env:    "SIMPLELISTS_TOTP_SECRET",
+		usage:  "base32 TOTP secret to enable two-factor sign in (see -gentotp)",
+		secret: true,
+		get:    func(c *Config) string { return c.TOTPSecret },
+		set:    func(c *Config, s string) error { c.TOTPSecret = s; return nil },
+	},
+	{
+		key:    "totp_recovery",
+		env:    "SIMPLELISTS_TOTP_RECOVERY",
+		usage:  "comma-separated TOTP recovery code hashes (see -gentotp)",
+		secret: true,
+		get:    func(c *Config) string { return c.TOTPRecovery },
+		set:    func(c *Config, s string) error { c.TOTPRecovery = s; return nil },
+	},
+	{
+		key:   "auth_header",
+		env:   "SIMPLELISTS_AUTH_HEADER",
+		usage: "trust this header (for example X-Forwarded-User) from trusted_proxies for the signed-in user",
+		get:   func(c *Config) string { return c.AuthHeader },
+		set:   func(c *Config, s string) error { c.AuthHeader = s; return nil },
+	},
+	{
+		key:   "trusted_proxies",
+		env:   "SIMPLELISTS_TRUSTED_PROXIES",
+		usage: "comma-separated IPs or CIDRs of proxies allowed to set auth_header and X-Forwarded-* headers, or \"unix\" for a Unix socket",
+		get:   func(c *Config) string { return c.TrustedProxies },
+		set:   func(c *Config, s string) error { c.TrustedProxies = s; return nil },
+	},
+	{
+		key:   "oidc_issuer",
+		env:   "SIMPLELISTS_OIDC_ISSUER",
+		usage: "OpenID Connect issuer URL to enable single sign-on, for example https://accounts.google.com",
+		get:   func(c *Config) string { return c.OIDCIssuer },
+		set:   func(c *Config, s string) error { c.OIDCIssuer = s; return nil },
+	},
+	{
+		key:   "oidc_client_id",
+		env:   "SIMPLELISTS_OIDC_CLIENT_ID",
+		usage: "OpenID Connect client ID",
+		get:   func(c *Config) string { return c.OIDCClientID },
+		set:   func(c *Config, s string) error { c.OIDCClientID = s; return nil },
+	},
+	{
+		key:    "oidc_client_secret",
+		env:    "SIMPLELISTS_OIDC_CLIENT_SECRET",
+		usage:  "OpenID Connect client secret (optional for public clients)",
+		secret: true,
+		get:    func(c *Config) string { return c.OIDCClientSecret },
+		set:    func(c *Config, s string) error { c.OIDCClientSecret = s; return nil },
+	},
+	{
+		key:   "oidc_redirect_url",
+		env:   "SIMPLELISTS_OIDC_REDIRECT_URL",
+		usage: "full URL of the /oidc-callback page registered with the issuer",
+		get:   func(c *Config) string { return c.OIDCRedirectURL },
+		set:   func(c *Config, s string) error { c.OIDCRedirectURL = s; return nil },
+	},
+	{
+		key:   "oidc_allowed_domains",
+		env:   "SIMPLELISTS_OIDC_ALLOWED_DOMAINS",
+		usage: "comma-separated email domains allowed to sign in with OpenID Connect (required), or * for any account at the issuer",
+		get:   func(c *Config) string { return c.OIDCAllowedDomains },
+		set:   func(c *Config, s string) error { c.OIDCAllowedDomains = s; return nil },
+	},
+	{
+		key:   "session_lifetime",
+		env:   "SIMPLELISTS_SESSION_LIFETIME",
+		usage: "maximum sign-in session lifetime, for example 90d or 12h (default 90d)",
+		get:   func(c *Config) string { return c.SessionLifetime },
+		set:   func(c *Config, s string) error { c.SessionLifetime = s; return nil },
+	},
+	{
+		key:   "session_idle_timeout",
+		env:   "SIMPLELISTS_SESSION_IDLE_TIMEOUT",
+		usage: "sign out sessions idle for this long, for example 7d (default none)",
+		get:   func(c *Config) string { return c.SessionIdleTimeout },
+		set:   func(c *Config, s string) error { c.SessionIdleTimeout = s; return nil },
+	},
+	{
+		key:   "session_renew_interval",
+		env:   "SIMPLELISTS_SESSION_RENEW_INTERVAL",
+		usage: "how often to update a session's last-used time (default 1m)",
+		get:   func(c *Config) string { return c.SessionRenewInterval },
+		set:   func(c *Config, s string) error { c.SessionRenewInterval = s; return nil },
+	},
+	{
+		key:   "content_security_policy",
+		env:   "SIMPLELISTS_CONTENT_SECURITY_POLICY",
+		usage: "Content-Security-Policy header (\"off\" to omit)",
+		get:   func(c *Config) string { return c.ContentSecurityPolicy },
+		set:   func(c *Config, s string) error { c.ContentSecurityPolicy = s; return nil },
+	},
+	{
+		key:   "frame_options",
+		env:   "SIMPLELISTS_FRAME_OPTIONS",
+		usage: "X-Frame-Options header: DENY, SAMEORIGIN, or off",
+		get:   func(c *Config) string { return c.FrameOptions },
+		set:   func(c *Config, s string) error { c.FrameOptions = s; return nil },
+	},
+	{
+		key:   "referrer_policy",
+		env:   "SIMPLELISTS_REFERRER_POLICY",
+		usage: "Referrer-Policy header (\"off\" to omit)",
+		get:   func(c *Config) string { return c.ReferrerPolicy },
+		set:   func(c *Config, s string) error { c.ReferrerPolicy = s; return nil },
+	},
+	{
+		key:   "permissions_policy",
+		env:   "SIMPLELISTS_PERMISSIONS_POLICY",
+		usage: "Permissions-Policy header (\"off\" to omit)",
+		get:   func(c *Config) string { return c.PermissionsPolicy },
+		set:   func(c *Config, s string) error { c.PermissionsPolicy = s; return nil },
+	},
+	{
+		key:   "hsts_max_age",
+		env:   "SIMPLELISTS_HSTS_MAX_AGE",
+		usage: "Strict-Transport-Security max age, for example 365d (default off)",
+		get:   func(c *Config) string { return c.HSTSMaxAge },
+		set:   func(c *Config, s string) error { c.HSTSMaxAge = s; return nil },
+	},
+	{
+		key:    "csrf_secret",
+		env:    "SIMPLELISTS_CSRF_SECRET",
+		usage:  "secret key for signing CSRF tokens (default random key stored in the database)",
+		secret: true,
+		get:    func(c *Config) string { return c.CSRFSecret },
+		set:    func(c *Config, s string) error { c.CSRFSecret = s; return nil },
+	},
+	{
+		key:    "metrics_token",
+		env:    "SIMPLELISTS_METRICS_TOKEN",
+		usage:  "bearer token required to access /metrics (optional)",
+		secret: true,
+		get:    func(c *Config) string { return c.MetricsToken },
+		set:    func(c *Config, s string) error { c.MetricsToken = s; return nil },
+	},
+	{
+		key:   "log_format",
+		env:   "SIMPLELISTS_LOG_FORMAT",
+		usage: "log format: logfmt or json",
+		get:   func(c *Config) string { return c.LogFormat },
+		set:   func(c *Config, s string) error { c.LogFormat = s; return nil },
+	},
+	{
+		key:   "log_level",
+		env:   "SIMPLELISTS_LOG_LEVEL",
+		usage: "log level: debug, info, warn, or error",
+		get:   func(c *Config) string { return c.LogLevel },
+		set:   func(c *Config, s string) error { c.LogLevel = s; return nil },
+	},
+}
+
+func setInt(p *int, s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("invalid integer %q", s)
+	}
+	*p = n
+	return nil
+}
+
+func setBool(p *bool, s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return fmt.Errorf("invalid boolean %q (must be true or false)", s)
+	}
+	*p = b
+	return nil
+}
+
+// ConfigFlags holds the config-related command-line flags.
+type ConfigFlags struct {
+	Path   string            // path to config file from -config flag
+	values map[string]string // flag values keyed by setting key
+}
+
+// AddConfigFlags adds the -config flag and a flag for each setting to fs.
+func AddConfigFlags(fs *flag.FlagSet) *ConfigFlags {
+	flags := &ConfigFlags{values: make(map[string]string)}
+	fs.StringVar(&flags.Path, "config", "", "-")
+	for _, st := range settings {
+		fs.Var(settingFlag{st, flags.values}, flagName(st), "-")
+	}
+	return flags
+}
+
+func flagName(st *setting) string {
+	return strings.Replace(st.key, "_", "-", -1)
+}
+
+// settingFlag is a flag.Value that records the value of a setting's flag.
+type settingFlag struct {
+	setting *setting
+	values  map[string]string
+}
+
+func (f settingFlag) String() string {
+	if f.setting == nil {
+		return ""
+	}
+	return f.values[f.setting.key]
+}
+
+func (f settingFlag) Set(s string) error {
+	// Check that it parses, but apply it later (flags override config file).
+	var config Config
+	err := f.setting.set(&config, s)
+	if err != nil {
+		return err
+	}
+	f.values[f.setting.key] = s
+	return nil
+}
+
+func (f settingFlag) IsBoolFlag() bool {
+	return f.setting != nil && f.setting.isBool
+}
+
+// LoadConfig loads the configuration, starting with the defaults, then
+// applying environment variables, then the config file (if any), then the
+// command-line flags. The config file path comes from the -config flag or the
+// SIMPLELISTS_CONFIG environment variable.
+func LoadConfig(flags *ConfigFlags, lookupEnv func(string) (string, bool)) (Config, error) {
+	config := DefaultConfig()
+
+	for _, st := range settings {
+		value, ok := lookupEnv(st.env)
+		if !ok {
+			continue
+		}
+		set := st.set
+		if st.setEnv != nil {
+			set = st.setEnv
+		}
+		err := set(&config, value)
+		if err != nil {
+			return Config{}, fmt.Errorf("environment variable %s: %v", st.env, err)
+		}
+	}
+
+	path := flags.Path
+	if path == "" {
+		path, _ = lookupEnv("SIMPLELISTS_CONFIG")
+	}
+	if path != "" {
+		err := loadConfigFile(&config, path)
+		if err != nil {
+			return Config{}, err
+		}
+	}
+
+	for _, st := range settings {
+		value, ok := flags.values[st.key]
+		if !ok {
+			continue
+		}
+		err := st.set(&config, value)
+		if err != nil {
+			return Config{}, fmt.Errorf("flag -%s: %v", flagName(st), err)
+		}
+	}
+
+	return config, nil
+}
+
+// loadConfigFile overwrites config fields with the values in the given JSON
+// config file (fields not in the file are left untouched).
+func loadConfigFile(config *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %v", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err = decoder.Decode(config)
+	if err != nil {
+		return fmt.Errorf("config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// Validate checks every configuration value, returning an error that lists
+// all the problems found (or nil if the config is valid).
+func (c *Config) Validate() error {
+	var problems []string
+	if c.Port < 1 || c.Port > 65535 {
+		problems = append(problems, fmt.Sprintf("port %d out of range (must be 1-65535)", c.Port))
+	}
+	if c.UnixSocket != "" && c.SystemdSocket {
+		problems = append(problems, "unix_socket and systemd_socket must not both be set")
+	}
+	_, err := parseFileMode(c.UnixSocketMode)
+	if err != nil {
+		problems = append(problems, "unix_socket_mode: "+err.Error())
+	}
+	if c.DB == "" {
+		problems = append(problems, "db must not be empty")
+	}
+	if c.BasePath != "" && (!strings.HasPrefix(c.BasePath, "/") || strings.ContainsAny(c.BasePath, "?#")) {
+		problems = append(problems, fmt.Sprintf("base_path %q invalid (must start with / and not contain ? or #)", c.BasePath))
+	}
+	if c.PublicURL != "" {
+		u, err := url.Parse(c.PublicURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("public_url %q invalid (must be an http or https URL)", c.PublicURL))
+		}
+	}
+	if c.Timezone != "" {
+		_, err := time.LoadLocation(c.Timezone)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("timezone: %v", err))
+		}
+	}
+	if c.Username != "" {
+		if c.PassHash == "" {
+			problems = append(problems, "passhash must be set if username is set")
+		} else {
+			err := CheckPasswordHash(c.PassHash)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("passhash: %v", err))
+			}
+		}
+	}
+	if c.TOTPSecret != "" {
+		if c.Username == "" {
+			problems = append(problems, "username must be set if totp_secret is set")
+		}
+		_, err := ParseTOTPSecret(c.TOTPSecret)
+		if err != nil {
+			problems = append(problems, "totp_secret: "+err.Error())
+		}
+	}
+	if c.TOTPRecovery != "" {
+		if c.TOTPSecret == "" {
+			problems = append(problems, "totp_secret must be set if totp_recovery is set")
+		}
+		_, err := ParseRecoveryHashes(c.TOTPRecovery)
+		if err != nil {
+			problems = append(problems, "totp_recovery: "+err.Error())
+		}
+	}
+	if c.AuthHeader != "" {
+		if c.Username != "" {
+			problems = append(problems, "username and auth_header must not both be set")
+		}
+		if c.TrustedProxies == "" {
+			problems = append(problems, "trusted_proxies must be set if auth_header is set")
+		}
+	}
+	if c.OIDCIssuer != "" {
+		if c.AuthHeader != "" {
+			problems = append(problems, "oidc_issuer and auth_header must not both be set")
+		}
+		u, err := url.Parse(c.OIDCIssuer)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("oidc_issuer %q invalid (must be an http or https URL)", c.OIDCIssuer))
+		}
+		if c.OIDCClientID == "" {
+			problems = append(problems, "oidc_client_id must be set if oidc_issuer is set")
+		}
+		if strings.TrimSpace(c.OIDCAllowedDomains) == "" {
+			problems = append(problems, "oidc_allowed_domains must be set if oidc_issuer is set (use * to allow any account at the issuer)")
+		}
+		u, err = url.Parse(c.OIDCRedirectURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("oidc_redirect_url %q invalid (must be an http or https URL)", c.OIDCRedirectURL))
+		}
+	}
+	if c.TrustedProxies != "" {
+		_, _, err := ParseTrustedProxies(c.TrustedProxies)
+		if err != nil {
+			problems = append(problems, "trusted_proxies: "+err.Error())
+		}
+	}
+	switch strings.ToUpper(c.FrameOptions) {
+	case "DENY", "SAMEORIGIN", "OFF", "":
+	default:
+		problems = append(problems, fmt.Sprintf("frame_options %q invalid (must be DENY, SAMEORIGIN, or off)", c.FrameOptions))
+	}
+	_, err = parseHSTSMaxAge(c.HSTSMaxAge)
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	if c.CSRFSecret != "" && len(c.CSRFSecret) < 16 {
+		problems = append(problems, "csrf_secret too short (must be at least 16 characters)")
+	}
+	_, _, _, err = c.sessionDurations()
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	if c.LogFormat != LogFormatLogfmt && c.LogFormat != LogFormatJSON {
+		problems = append(problems, fmt.Sprintf("log_format %q invalid (must be %s or %s)",
+			c.LogFormat, LogFormatLogfmt, LogFormatJSON))
+	}
+	_, err = ParseLogLevel(c.LogLevel)
+	if err != nil {
+		problems = append(problems, "log_level: "+err.Error())
+	}
+	if len(problems) > 0 {
+		return errors.New("invalid config:\n  " + strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// Session duration defaults.
+const (
+	defaultSessionLifetime      = 90 * 24 * time.Hour
+	defaultSessionRenewInterval = time.Minute
+)
+
+// sessionDurations parses and checks the session duration settings,
+// returning the defaults for settings that aren't set. An idle timeout of
+// zero means sessions don't time out when idle.
+func (c *Config) sessionDurations() (lifetime, idleTimeout, renewInterval time.Duration, err error) {
+	lifetime, err = parseDuration(c.SessionLifetime, defaultSessionLifetime)
+	if err != nil || lifetime <= 0 {
+		return 0, 0, 0, fmt.Errorf("session_lifetime %q invalid (must be a positive duration like 90d or 12h)", c.SessionLifetime)
+	}
+	idleTimeout, err = parseDuration(c.SessionIdleTimeout, 0)
+	if err != nil || idleTimeout < 0 {
+		return 0, 0, 0, fmt.Errorf("session_idle_timeout %q invalid (must be a duration like 7d or 30m)", c.SessionIdleTimeout)
+	}
+	renewInterval, err = parseDuration(c.SessionRenewInterval, defaultSessionRenewInterval)
+	if err != nil || renewInterval <= 0 {
+		return 0, 0, 0, fmt.Errorf("session_renew_interval %q invalid (must be a positive duration like 1m)", c.SessionRenewInterval)
+	}
+	if idleTimeout > 0 && renewInterval >= idleTimeout {
+		return 0, 0, 0, fmt.Errorf("session_renew_interval (%v) must be less than session_idle_timeout (%v)", renewInterval, idleTimeout)
+	}
+	return lifetime, idleTimeout, renewInterval, nil
+}
+
+// parseDuration parses a duration like time.ParseDuration, but also allows
+// a number of days like "90d". If s is empty, it returns def.
+func parseDuration(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.ParseFloat(s[:len(s)-1], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(days * float64(24*time.Hour)), nil
+	}
+	return time.ParseDuration(s)
+}
+
+// Print writes the config to w as JSON (in config file format), with secret
+// values redacted.
+func (c *Config) Print(w io.Writer) error {
+	redacted := *c
+	for _, st := range settings {
+		if st.secret && st.get(&redacted) != "" {
+			st.set(&redacted, "REDACTED")
+		}
+	}
+	b, err := json.MarshalIndent(redacted, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
+
+// printSettingsUsage writes the usage text for each setting.
+func printSettingsUsage(w io.Writer) {
+	defaults := DefaultConfig()
+	for _, st := range settings {
+		fmt.Fprintf(w, "  -%s, %s, config key %q\n", flagName(st), st.env, st.key)
+		fmt.Fprintf(w, "        %s", st.usage)
+		if def := st.get(&defaults); def != "" && !st.isBool {
+			fmt.Fprintf(w, " (default %q)", def)
+		}
+		fmt.Fprintln(w)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(path, []byte(`{"port": 9000, "timezone": "Pacific/Auckland", "lists": true}`), 0o600)
+	if err != nil {
+		t.Fatalf("writing config file: %v", err)
+	}
+	env := map[string]string{
+		"PORT":                 "8000",
+		"SIMPLELISTS_DB":       "env.sqlite",
+		"SIMPLELISTS_TIMEZONE": "UTC",
+		"SIMPLELISTS_CONFIG":   path,
+	}
+	lookupEnv := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	flags := AddConfigFlags(fs)
+	err = fs.Parse([]string{"-port", "7000", "-log-level", "debug"})
+	if err != nil {
+		t.Fatalf("parsing flags: %v", err)
+	}
+
+	config, err := LoadConfig(flags, lookupEnv)
+	if err != nil {
+		t.Fatalf("loading config: %v", err)
+	}
+	ensureInt(t, config.Port, 7000)                      // flag overrides file and env
+	ensureString(t, config.Timezone, "Pacific/Auckland") // file overrides env
+	ensureString(t, config.DB, "env.sqlite")             // env overrides default
+	ensureString(t, config.LogLevel, "debug")
+	ensureString(t, config.LogFormat, LogFormatLogfmt) // default
+	if !config.Lists {
+		t.Fatalf("expected lists to be true")
+	}
+	err = config.Validate()
+	if err != nil {
+		t.Fatalf("validating config: %v", err)
+	}
+
+	// Invalid values are reported clearly
+	env["SIMPLELISTS_SYSTEMD_SOCKET"] = "yes"
+	_, err = LoadConfig(flags, lookupEnv)
+	ensureString(t, errString(err), `environment variable SIMPLELISTS_SYSTEMD_SOCKET: invalid boolean "yes" (must be true or false)`)
+	delete(env, "SIMPLELISTS_SYSTEMD_SOCKET")
+
+	// SIMPLELISTS_LISTS keeps its original meaning: only 1 or "true" is true
+	env["SIMPLELISTS_CONFIG"] = ""
+	for value, want := range map[string]bool{"1": true, "true": true, "": false, "yes": false, "0": false} {
+		env["SIMPLELISTS_LISTS"] = value
+		config, err := LoadConfig(&ConfigFlags{}, lookupEnv)
+		if err != nil {
+			t.Fatalf("loading config: %v", err)
+		}
+		if config.Lists != want {
+			t.Fatalf("SIMPLELISTS_LISTS=%q: got lists %v, want %v", value, config.Lists, want)
+		}
+	}
+	delete(env, "SIMPLELISTS_LISTS")
+	env["SIMPLELISTS_CONFIG"] = path
+
+	err = os.WriteFile(path, []byte(`{"prot": 9000}`), 0o600)
+	if err != nil {
+		t.Fatalf("writing config file: %v", err)
+	}
+	_, err = LoadConfig(flags, lookupEnv)
+	ensureString(t, errString(err), "config file "+path+`: json: unknown field "prot"`)
+
+	err = fs.Parse([]string{"-port", "x"})
+	if err == nil {
+		t.Fatalf("expected error parsing invalid -port flag")
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	config := DefaultConfig()
+	config.Port = 0
+	config.Timezone = "Nowhere/Special"
+	config.Username = "bob"
+	config.LogFormat = "xml"
+	config.LogLevel = "loud"
+	config.PublicURL = "lists.example.com"
+	config.OIDCIssuer = "https://accounts.google.com"
+	config.OIDCClientID = "simplelists"
+	config.OIDCRedirectURL = "https://lists.example.com/oidc-callback"
+	err := config.Validate()
+	if err == nil {
+		t.Fatalf("expected validation error")
+	}
+	for _, problem := range []string{"port 0", "timezone", "passhash must be set", "log_format", "log_level", "public_url", "oidc_allowed_domains"} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("error %q doesn't contain %q", err, problem)
+		}
+	}
+}
+
+func TestPrintConfigRedactsSecrets(t *testing.T) {
+	config := DefaultConfig()
+	config.Username = "bob"
+	config.PassHash = "$2a$10$secretsecretsecretsecret"
+	config.MetricsToken = "t0ken"
+	var buf bytes.Buffer
+	err := config.Print(&buf)
+	if err != nil {
+		t.Fatalf("printing config: %v", err)
+	}
+	output := buf.String()
+	for _, secret := range []string{config.PassHash, config.MetricsToken} {
+		if strings.Contains(output, secret) {
+			t.Errorf("output contains secret %q:\n%s", secret, output)
+		}
+	}
+	if !strings.Contains(output, `"username": "bob"`) {
+		t.Errorf("output doesn't contain username:\n%s", output)
+	}
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// csrfKeySize is the size in bytes of the generated CSRF signing key.
+const csrfKeySize = 32
+
+// loadCSRFKey returns the key used to sign CSRF tokens: the configured
+// secret if set, otherwise a random key generated the first time and stored
+// in the database (so tokens survive restarts).
+func (s *Server) loadCSRFKey(secret string) ([]byte, error) {
+	if secret != "" {
+		return []byte(secret), nil
+	}
+	key := make([]byte, csrfKeySize)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.model.EnsureSecret("csrf", key)
+}
+
+// csrf wraps the given handler, ensuring that the HTTP method is POST, that
+// the request came from this site (according to the Origin or Referer
+// header), and that the "csrf-token" form field is valid for the session.
+func (s *Server) csrf(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			s.errorPage(w, r, http.StatusMethodNotAllowed, "Method not allowed",
+				"This page only accepts form submissions.")
+			return
+		}
+		binding, session := s.csrfBinding(nil, r)
+		if !s.isSameOrigin(r, session) {
+			s.logger.Log(LevelWarn, "cross-origin form submission", "origin", r.Header.Get("Origin"),
+				"referer", r.Referer(), "request_id", getRequestInfo(r).id)
+			s.errorPage(w, r, http.StatusForbidden, "Form blocked",
+				"This form was submitted from another site, so it was ignored.")
+			return
+		}
+		token := r.FormValue("csrf-token")
+		if binding == "" || !hmac.Equal([]byte(token), []byte(s.signCSRFToken(binding))) {
+			s.errorPage(w, r, http.StatusBadRequest, "Form expired",
+				"This form has expired, probably because you signed in or out in another tab. "+
+					"Please go back, reload the page, and try again.")
+			return
+		}
+		h(w, r)
+	}
+}
+
+// getCSRFToken returns the CSRF token for the request's session, setting
+// the "csrf-id" cookie if the token needs one (see csrfBinding).
+func (s *Server) getCSRFToken(w http.ResponseWriter, r *http.Request) string {
+	binding, _ := s.csrfBinding(w, r)
+	return s.signCSRFToken(binding)
+}
+
+// signCSRFToken returns the CSRF token for the given binding: an HMAC of it,
+// so tokens can't be forged or reused across sessions.
+func (s *Server) signCSRFToken(binding string) string {
+	mac := hmac.New(sha256.New, s.csrfKey)
+	mac.Write([]byte("csrf-token\x00" + binding))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// csrfBinding returns what the request's CSRF token is bound to. That's the
+// sign-in (or pending sign-in) ID, or the proxy's user in proxy auth mode, so
+// it changes whenever the user signs in or out. Otherwise it's a random
+// per-browser ID from the "csrf-id" cookie, which is set on w if it's not
+// present (if w is nil, the binding is "" instead). The session result
+// reports whether the binding is a real session rather than that cookie.
+func (s *Server) csrfBinding(w http.ResponseWriter, r *http.Request) (binding string, session bool) {
+	if s.authHeader != "" {
+		if user := s.proxyUser(r); user != "" {
+			return "proxy-user:" + user, true
+		}
+	} else if id := getSignInCookie(r); id != "" {
+		return "sign-in:" + id, true
+	} else if id := getCookie(r, "sign-in-pending"); id != "" {
+		return "pending:" + id, true
+	}
+	id := getCookie(r, "csrf-id")
+	if id == "" {
+		if w == nil {
+			return "", false
+		}
+		id = generateCSRFID()
+		cookie := &http.Cookie{
+			Name:     "csrf-id",
+			Value:    id,
+			Path:     s.cookiePath(),
+			Secure:   r.URL.Scheme == "https",
+			HttpOnly: true,
+			SameSite: http.SameSiteStrictMode,
+		}
+		http.SetCookie(w, cookie)
+	}
+	return "anonymous:" + id, false
+}
+
+// generateCSRFID returns a random ID for the "csrf-id" cookie.
+func generateCSRFID() string {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil { // should never fail
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// isSameOrigin reports whether the request's Origin header (or Referer
+// header if there's no Origin) is for this site: the configured public URL,
+// or otherwise the request's Host (or X-Forwarded-Host from a trusted
+// proxy). Behind a proxy that changes the Host header, public_url must be
+// set for forms to work. If neither header is present, it only returns true
+// if the request is for a real session (see csrfBinding), as then the CSRF
+// token alone is enough protection.
+func (s *Server) isSameOrigin(r *http.Request, session bool) bool {
+	source := r.Header.Get("Origin")
+	if source == "" {
+		source = r.Referer()
+	}
+	if source == "" {
+		return session
+	}
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" {
+		return false // includes "Origin: null"
+	}
+	if s.publicOrigin != "" {
+		return strings.EqualFold(u.Scheme+"://"+u.Host, s.publicOrigin)
+	}
+	host := r.Host
+	if forwarded := r.Header.Get("X-Forwarded-Host"); forwarded != "" && s.isTrustedProxy(r) {
+		host = strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+	return strings.EqualFold(u.Host, host)
+}
+
+// errorPage writes a friendly HTML error page with the given status code.
+func (s *Server) errorPage(w http.ResponseWriter, r *http.Request, status int, title, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	data := struct {
+		Title   string
+		Message string
+	}{title, message}
+	err := s.errorTmpl.Execute(w, data)
+	if err != nil {
+		s.logger.Log(LevelError, "error rendering template", "error", err,
+			"request_id", getRequestInfo(r).id)
+	}
+}
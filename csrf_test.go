@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSRF(t *testing.T) {
+	config := Config{Lists: true, TrustedProxies: "10.0.0.1"}
+	server, model := newTestServer(t, nullLogger{}, config)
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("creating cookie jar: %v", err)
+	}
+	recorder := serve(t, server, jar, "GET", "/", nil)
+	forms := parseForms(t, recorder.Body.String())
+	token := forms[0].Inputs["csrf-token"]
+
+	post := func(server *Server, jar http.CookieJar, token string, header ...string) *httptest.ResponseRecorder {
+		t.Helper()
+		form := url.Values{}
+		form.Set("csrf-token", token)
+		r := httptest.NewRequest("POST", "http://localhost/create-list", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for _, c := range jar.Cookies(r.URL) {
+			r.AddCookie(c)
+		}
+		for i := 0; i < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, r)
+		return recorder
+	}
+
+	// Token is checked, with a friendly error page
+	recorder = post(server, jar, token, "Origin", "http://localhost")
+	ensureRedirect(t, recorder, http.StatusFound, "/")
+	recorder = post(server, jar, token+"x", "Origin", "http://localhost")
+	ensureCode(t, recorder, http.StatusBadRequest)
+	if !strings.Contains(recorder.Body.String(), "<h1>Form expired</h1>") {
+		t.Fatalf("friendly error page not shown:\n%s", recorder.Body.String())
+	}
+
+	// Anonymous tokens are tied to a random per-browser cookie
+	otherJar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("creating cookie jar: %v", err)
+	}
+	recorder = serve(t, server, otherJar, "GET", "/", nil)
+	otherToken := parseForms(t, recorder.Body.String())[0].Inputs["csrf-token"]
+	if otherToken == token {
+		t.Fatalf("anonymous browsers got the same token %q", token)
+	}
+	recorder = post(server, jar, otherToken, "Origin", "http://localhost")
+	ensureCode(t, recorder, http.StatusBadRequest)
+	emptyJar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("creating cookie jar: %v", err)
+	}
+	recorder = post(server, emptyJar, server.signCSRFToken(""), "Origin", "http://localhost")
+	ensureCode(t, recorder, http.StatusBadRequest)
+
+	// Origin or Referer must match the host, and one of them is required
+	// without a real session
+	recorder = post(server, jar, token)
+	ensureCode(t, recorder, http.StatusForbidden)
+	recorder = post(server, jar, token, "Origin", "http://localhost")
+	ensureCode(t, recorder, http.StatusFound)
+	recorder = post(server, jar, token, "Referer", "http://localhost/lists/abc")
+	ensureCode(t, recorder, http.StatusFound)
+	recorder = post(server, jar, token, "Origin", "https://evil.example.com", "Referer", "http://localhost/")
+	ensureCode(t, recorder, http.StatusForbidden)
+	recorder = post(server, jar, token, "Referer", "https://evil.example.com/localhost")
+	ensureCode(t, recorder, http.StatusForbidden)
+	recorder = post(server, jar, token, "Origin", "null")
+	ensureCode(t, recorder, http.StatusForbidden)
+
+	// X-Forwarded-Host is only used from trusted proxies
+	recorder = post(server, jar, token, "Origin", "https://lists.example.com", "X-Forwarded-Host", "lists.example.com")
+	ensureCode(t, recorder, http.StatusForbidden)
+	proxied, _ := newTestServer(t, nullLogger{}, Config{TrustedProxies: "192.0.2.1"}) // httptest.NewRequest's address
+	proxiedRecorder := httptest.NewRecorder()
+	proxiedToken := proxied.getCSRFToken(proxiedRecorder, httptest.NewRequest("GET", "/", nil))
+	proxiedJar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("creating cookie jar: %v", err)
+	}
+	proxiedJar.SetCookies(&url.URL{Scheme: "http", Host: "localhost"}, proxiedRecorder.Result().Cookies())
+	recorder = post(proxied, proxiedJar, proxiedToken, "Origin", "https://lists.example.com", "X-Forwarded-Host", "lists.example.com")
+	ensureCode(t, recorder, http.StatusFound)
+
+	// The public URL's origin is expected if set, for example behind a
+	// proxy that doesn't pass on the Host header
+	public, _ := newTestServer(t, nullLogger{}, Config{PublicURL: "https://lists.example.com/lists-app"})
+	publicRecorder := httptest.NewRecorder()
+	publicToken := public.getCSRFToken(publicRecorder, httptest.NewRequest("GET", "/", nil))
+	publicJar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("creating cookie jar: %v", err)
+	}
+	publicJar.SetCookies(&url.URL{Scheme: "http", Host: "localhost"}, publicRecorder.Result().Cookies())
+	recorder = post(public, publicJar, publicToken, "Origin", "https://lists.example.com")
+	ensureCode(t, recorder, http.StatusFound)
+	recorder = post(public, publicJar, publicToken, "Referer", "https://lists.example.com/lists-app/")
+	ensureCode(t, recorder, http.StatusFound)
+	recorder = post(public, publicJar, publicToken, "Origin", "http://localhost")
+	ensureCode(t, recorder, http.StatusForbidden)
+	recorder = post(public, publicJar, publicToken, "Origin", "http://lists.example.com")
+	ensureCode(t, recorder, http.StatusForbidden)
+
+	// Signing key is stored in the database, so tokens survive a restart,
+	// unless a secret is configured
+	restarted, err := NewServer(model, nullLogger{}, config)
+	if err != nil {
+		t.Fatalf("creating server: %v", err)
+	}
+	recorder = post(restarted, jar, token, "Origin", "http://localhost")
+	ensureCode(t, recorder, http.StatusFound)
+	config.CSRFSecret = "0123456789abcdef"
+	restarted, err = NewServer(model, nullLogger{}, config)
+	if err != nil {
+		t.Fatalf("creating server: %v", err)
+	}
+	recorder = post(restarted, jar, token, "Origin", "http://localhost")
+	ensureCode(t, recorder, http.StatusBadRequest)
+
+	// Only POST is allowed
+	recorder = serve(t, server, jar, "GET", "/create-list", nil)
+	ensureCode(t, recorder, http.StatusMethodNotAllowed)
+	ensureString(t, recorder.Result().Header.Get("Allow"), "POST")
+}
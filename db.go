@@ -1,6 +1,8 @@
 package main
 
 import (
+	cryptorand "crypto/rand"
+	"crypto/sha256"
 	"database/sql"
 	"encoding/hex"
 	"math/rand"
@@ -13,6 +15,7 @@ type List struct {
 	ID          string
 	TimeCreated time.Time
 	Name        string
+	Template    bool // shown in the "new from template" picker
 	Items       []*Item
 }
 
@@ -21,6 +24,22 @@ type Item struct {
 	ID          string
 	Description string
 	Done        bool
+	TimeDue     time.Time // zero if item has no due date
+	DueAllDay   bool      // true if due on a date with no time (TimeDue is start of day)
+	Repeat      string    // repeat rule, for example "weekly:mon,thu" ("" if it doesn't repeat)
+	Notes       string    // optional multi-line notes
+	ParentID    string    // ID of parent item, or "" for a top-level item
+	Depth       int       // nesting level (0 for top-level items)
+	Heading     bool      // true if this is a section heading rather than an item
+	Collapsed   bool      // true if this is a heading and its section is collapsed
+	Repeated    bool      // true if done and copied for the next occurrence of its repeat rule
+}
+
+// Stats holds counts of the main database objects.
+type Stats struct {
+	Lists   int
+	Items   int
+	SignIns int
 }
 
 // SQLModel represents the database query model implemented with SQLite.
@@ -39,7 +58,8 @@ func NewSQLModel(db *sql.DB) (*SQLModel, error) {
 			id VARCHAR(10) NOT NULL PRIMARY KEY,
 			time_created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
 			name VARCHAR(255) NOT NULL,
-		    time_deleted TIMESTAMP
+		    time_deleted TIMESTAMP,
+			template BOOLEAN NOT NULL DEFAULT FALSE
 		);
 		
 		CREATE TABLE IF NOT EXISTS items (
@@ -48,24 +68,223 @@ func NewSQLModel(db *sql.DB) (*SQLModel, error) {
 			time_created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
 			description VARCHAR(255) NOT NULL,
 		    done BOOLEAN NOT NULL DEFAULT FALSE,
-		    time_deleted TIMESTAMP
+		    time_deleted TIMESTAMP,
+			time_due TIMESTAMP,
+			due_all_day BOOLEAN NOT NULL DEFAULT FALSE,
+			repeat VARCHAR(64) NOT NULL DEFAULT '',
+			notes TEXT NOT NULL DEFAULT '',
+			parent_id INTEGER REFERENCES items(id),
+			position INTEGER NOT NULL DEFAULT 0,
+			heading BOOLEAN NOT NULL DEFAULT FALSE,
+			collapsed BOOLEAN NOT NULL DEFAULT FALSE
 		);
 		
 		CREATE INDEX IF NOT EXISTS items_list_id ON items(list_id);
 
+		CREATE TABLE IF NOT EXISTS tags (
+			id INTEGER NOT NULL PRIMARY KEY,
+			name VARCHAR(64) NOT NULL UNIQUE
+		);
+
+		CREATE TABLE IF NOT EXISTS item_tags (
+			item_id INTEGER NOT NULL REFERENCES items(id),
+			tag_id INTEGER NOT NULL REFERENCES tags(id),
+			PRIMARY KEY (item_id, tag_id)
+		);
+
+		CREATE INDEX IF NOT EXISTS item_tags_tag_id ON item_tags(tag_id);
+
 		CREATE TABLE IF NOT EXISTS sign_ins (
 		    id VARCHAR(64) NOT NULL PRIMARY KEY,
-			time_created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+			time_created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			time_last_seen TIMESTAMP,
+			user_agent VARCHAR(255) NOT NULL DEFAULT '',
+			ip VARCHAR(64) NOT NULL DEFAULT '',
+			username VARCHAR(255) NOT NULL DEFAULT ''
+		);
+
+		CREATE TABLE IF NOT EXISTS sign_in_failures (
+			key VARCHAR(255) NOT NULL PRIMARY KEY,
+			failures INTEGER NOT NULL,
+			time_last_failure TIMESTAMP NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS pending_sign_ins (
+			id VARCHAR(64) NOT NULL PRIMARY KEY,
+			time_created TIMESTAMP NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS used_second_factors (
+			value VARCHAR(255) NOT NULL PRIMARY KEY,
+			time_used TIMESTAMP NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS secrets (
+			name VARCHAR(255) NOT NULL PRIMARY KEY,
+			value BLOB NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS users (
+			username VARCHAR(255) NOT NULL PRIMARY KEY,
+			time_created TIMESTAMP NOT NULL
 		);
 		`)
+	if err != nil {
+		return nil, err
+	}
+	err = model.migrate()
 	return model, err
 }
 
+// migrate updates the schema of tables created by older versions.
+func (m *SQLModel) migrate() error {
+	columns := []struct {
+		table      string
+		column     string
+		definition string
+	}{
+		{"sign_ins", "time_last_seen", "TIMESTAMP"},
+		{"sign_ins", "user_agent", "VARCHAR(255) NOT NULL DEFAULT ''"},
+		{"sign_ins", "ip", "VARCHAR(64) NOT NULL DEFAULT ''"},
+		{"sign_ins", "username", "VARCHAR(255) NOT NULL DEFAULT ''"},
+		{"items", "time_due", "TIMESTAMP"},
+		{"items", "due_all_day", "BOOLEAN NOT NULL DEFAULT FALSE"},
+		{"items", "repeat", "VARCHAR(64) NOT NULL DEFAULT ''"},
+		{"items", "notes", "TEXT NOT NULL DEFAULT ''"},
+		{"items", "parent_id", "INTEGER REFERENCES items(id)"},
+		{"items", "position", "INTEGER NOT NULL DEFAULT 0"},
+		{"items", "heading", "BOOLEAN NOT NULL DEFAULT FALSE"},
+		{"items", "collapsed", "BOOLEAN NOT NULL DEFAULT FALSE"},
+		{"items", "next_id", "INTEGER REFERENCES items(id)"},
+		{"lists", "template", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	}
+	for _, c := range columns {
+		err := m.addColumn(c.table, c.column, c.definition)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Data migrations, applied in order and recorded in SQLite's user_version
+	dataMigrations := []func(tx *sql.Tx) error{
+		hashSignInIDs,
+		tagItems,
+		positionItems,
+	}
+	var version int
+	err := m.db.QueryRow("PRAGMA user_version").Scan(&version)
+	if err != nil {
+		return err
+	}
+	for i := version; i < len(dataMigrations); i++ {
+		tx, err := m.db.Begin()
+		if err != nil {
+			return err
+		}
+		err = dataMigrations[i](tx)
+		if err == nil {
+			_, err = tx.Exec("PRAGMA user_version = " + strconv.Itoa(i+1))
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hashSignInIDs replaces the raw sign-in IDs stored by older versions with
+// their hashes, so that existing sign-ins remain valid.
+func hashSignInIDs(tx *sql.Tx) error {
+	for _, table := range []string{"sign_ins", "pending_sign_ins"} {
+		rows, err := tx.Query("SELECT id FROM " + table)
+		if err != nil {
+			return err
+		}
+		var ids []string
+		for rows.Next() {
+			var id string
+			err = rows.Scan(&id)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if rows.Err() != nil {
+			return rows.Err()
+		}
+		for _, id := range ids {
+			_, err = tx.Exec("UPDATE "+table+" SET id = ? WHERE id = ?", hashSignInID(id), id)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// tagItems adds the tags in the descriptions of items created by older
+// versions, which didn't store tags.
+func tagItems(tx *sql.Tx) error {
+	rows, err := tx.Query("SELECT id, description FROM items WHERE description LIKE '%#%'")
+	if err != nil {
+		return err
+	}
+	var ids, descriptions []string
+	for rows.Next() {
+		var id, description string
+		err = rows.Scan(&id, &description)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+		descriptions = append(descriptions, description)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return rows.Err()
+	}
+	for i, id := range ids {
+		err = addItemTags(tx, id, parseTags(descriptions[i]))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// positionItems orders the items created by older versions, which were
+// ordered by ID.
+func positionItems(tx *sql.Tx) error {
+	_, err := tx.Exec("UPDATE items SET position = id")
+	return err
+}
+
+// addColumn adds a column to the given table if it doesn't already exist.
+func (m *SQLModel) addColumn(table, column, definition string) error {
+	row := m.db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?",
+		table, column)
+	var count int
+	err := row.Scan(&count)
+	if err != nil || count > 0 {
+		return err
+	}
+	_, err = m.db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + definition)
+	return err
+}
+
 // GetLists fetches all the to-do lists (without their items), ordered with
 // the most recent first.
 func (m *SQLModel) GetLists() ([]*List, error) {
 	rows, err := m.db.Query(`
-		SELECT id, name, time_created
+		SELECT id, name, time_created, template
 		FROM lists
 		WHERE time_deleted IS NULL
 		ORDER BY time_created DESC
@@ -78,7 +297,7 @@ func (m *SQLModel) GetLists() ([]*List, error) {
 	var lists []*List
 	for rows.Next() {
 		var list List
-		err = rows.Scan(&list.ID, &list.Name, &list.TimeCreated)
+		err = rows.Scan(&list.ID, &list.Name, &list.TimeCreated, &list.Template)
 		if err != nil {
 			return nil, err
 		}
@@ -97,6 +316,96 @@ func (m *SQLModel) CreateList(name string) (string, error) {
 	return id, err
 }
 
+// CopyList creates a new list with the given name and a copy of the items in
+// list id (all not done), returning the new list's ID.
+func (m *SQLModel) CopyList(id, name string) (string, error) {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	newID := m.makeListID(10)
+	timeCreated := time.Now().In(time.UTC).Format(time.RFC3339Nano)
+	_, err = tx.Exec("INSERT INTO lists (id, name, time_created) VALUES (?, ?, ?)",
+		newID, name, timeCreated)
+	if err != nil {
+		return "", err
+	}
+
+	rows, err := tx.Query(`
+		SELECT id, parent_id
+		FROM items
+		WHERE list_id = ? AND time_deleted IS NULL
+		ORDER BY position, id
+		`, id)
+	if err != nil {
+		return "", err
+	}
+	var itemIDs []int64
+	parentIDs := make(map[int64]int64)
+	for rows.Next() {
+		var itemID int64
+		var parentID sql.NullInt64
+		err = rows.Scan(&itemID, &parentID)
+		if err != nil {
+			rows.Close()
+			return "", err
+		}
+		itemIDs = append(itemIDs, itemID)
+		if parentID.Valid {
+			parentIDs[itemID] = parentID.Int64
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return "", err
+	}
+
+	// Copy items and their tags, then point the copies at their new parents
+	newIDs := make(map[int64]int64)
+	for _, itemID := range itemIDs {
+		result, err := tx.Exec(`
+			INSERT INTO items (list_id, description, time_due, due_all_day, repeat, notes, position,
+				heading, collapsed)
+			SELECT ?, description, time_due, due_all_day, repeat, notes, position, heading, collapsed
+			FROM items
+			WHERE id = ?
+			`, newID, itemID)
+		if err != nil {
+			return "", err
+		}
+		newIDs[itemID], err = result.LastInsertId()
+		if err != nil {
+			return "", err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO item_tags (item_id, tag_id)
+			SELECT ?, tag_id FROM item_tags WHERE item_id = ?
+			`, newIDs[itemID], itemID)
+		if err != nil {
+			return "", err
+		}
+	}
+	for itemID, parentID := range parentIDs {
+		newParentID, ok := newIDs[parentID]
+		if !ok {
+			continue // parent was deleted
+		}
+		_, err = tx.Exec("UPDATE items SET parent_id = ? WHERE id = ?", newParentID, newIDs[itemID])
+		if err != nil {
+			return "", err
+		}
+	}
+	return newID, tx.Commit()
+}
+
+// SetListTemplate sets whether the given list is a template.
+func (m *SQLModel) SetListTemplate(id string, template bool) error {
+	_, err := m.db.Exec("UPDATE lists SET template = ? WHERE id = ?", template, id)
+	return err
+}
+
 var listIDChars = "bcdfghjklmnpqrstvwxyz" // just consonants to avoid spelling words
 
 // makeListID creates a new randomized list ID.
@@ -119,12 +428,12 @@ func (m *SQLModel) DeleteList(id string) error {
 // GetList fetches one list and returns it, or nil if not found.
 func (m *SQLModel) GetList(id string) (*List, error) {
 	row := m.db.QueryRow(`
-		SELECT id, name
+		SELECT id, name, template
 		FROM lists
 		WHERE id = ? AND time_deleted IS NULL
 		`, id)
 	var list List
-	err := row.Scan(&list.ID, &list.Name)
+	err := row.Scan(&list.ID, &list.Name, &list.Template)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -135,12 +444,15 @@ func (m *SQLModel) GetList(id string) (*List, error) {
 	return &list, err
 }
 
+// getListItems fetches a list's items (and section headings) in order, with
+// each item's children (in order) directly after it.
 func (m *SQLModel) getListItems(listID string) ([]*Item, error) {
 	rows, err := m.db.Query(`
-		SELECT id, description, done
+		SELECT id, description, done, time_due, due_all_day, repeat, notes, parent_id,
+			heading, collapsed, next_id IS NOT NULL
 		FROM items
 		WHERE list_id = ? AND time_deleted IS NULL
-		ORDER BY id
+		ORDER BY position, id
 		`, listID)
 	if err != nil {
 		return nil, err
@@ -150,20 +462,174 @@ func (m *SQLModel) getListItems(listID string) ([]*Item, error) {
 	var items []*Item
 	for rows.Next() {
 		var item Item
-		err = rows.Scan(&item.ID, &item.Description, &item.Done)
+		var due sql.NullTime
+		var parentID sql.NullString
+		err = rows.Scan(&item.ID, &item.Description, &item.Done, &due, &item.DueAllDay,
+			&item.Repeat, &item.Notes, &parentID, &item.Heading, &item.Collapsed, &item.Repeated)
+		if err != nil {
+			return nil, err
+		}
+		if due.Valid {
+			item.TimeDue = due.Time
+		}
+		item.ParentID = parentID.String
+		items = append(items, &item)
+	}
+	if rows.Err() != nil {
+		return nil, rows.Err()
+	}
+	return nestItems(items), nil
+}
+
+// nestItems orders items so that each item's children follow it, and sets
+// their Depth. Items whose parent isn't in the list are treated as top-level.
+func nestItems(items []*Item) []*Item {
+	ids := make(map[string]bool)
+	for _, item := range items {
+		ids[item.ID] = true
+	}
+	children := make(map[string][]*Item)
+	var topLevel []*Item
+	for _, item := range items {
+		if ids[item.ParentID] {
+			children[item.ParentID] = append(children[item.ParentID], item)
+		} else {
+			topLevel = append(topLevel, item)
+		}
+	}
+	nested := make([]*Item, 0, len(items))
+	var add func(item *Item, depth int)
+	add = func(item *Item, depth int) {
+		item.Depth = depth
+		nested = append(nested, item)
+		for _, child := range children[item.ID] {
+			add(child, depth+1)
+		}
+	}
+	for _, item := range topLevel {
+		add(item, 0)
+	}
+	return nested
+}
+
+// ListItem is an item along with the list it's on, for pages that show
+// items from all lists.
+type ListItem struct {
+	*Item
+	ListID   string
+	ListName string
+}
+
+// GetDueItems fetches the undone items due before the given time across all
+// lists, ordered by due time.
+func (m *SQLModel) GetDueItems(before time.Time) ([]*ListItem, error) {
+	rows, err := m.db.Query(`
+		SELECT items.id, items.description, items.time_due, items.due_all_day, items.repeat,
+			items.notes, lists.id, lists.name
+		FROM items
+		JOIN lists ON lists.id = items.list_id
+		WHERE items.time_deleted IS NULL AND lists.time_deleted IS NULL
+			AND NOT items.done AND items.time_due < ?
+		ORDER BY items.time_due, items.id
+		`, formatSQLTime(before))
+	if err != nil {
+		return nil, err
+	}
+	return scanListItems(rows)
+}
+
+// GetTagItems fetches the undone items with the given tag across all lists,
+// ordered by list and then the order they were added.
+func (m *SQLModel) GetTagItems(tag string) ([]*ListItem, error) {
+	rows, err := m.db.Query(`
+		SELECT items.id, items.description, items.time_due, items.due_all_day, items.repeat,
+			items.notes, lists.id, lists.name
+		FROM items
+		JOIN lists ON lists.id = items.list_id
+		JOIN item_tags ON item_tags.item_id = items.id
+		JOIN tags ON tags.id = item_tags.tag_id
+		WHERE items.time_deleted IS NULL AND lists.time_deleted IS NULL
+			AND NOT items.done AND tags.name = ?
+		ORDER BY lists.name, lists.id, items.id
+		`, tag)
+	if err != nil {
+		return nil, err
+	}
+	return scanListItems(rows)
+}
+
+func scanListItems(rows *sql.Rows) ([]*ListItem, error) {
+	defer rows.Close()
+
+	var items []*ListItem
+	for rows.Next() {
+		item := ListItem{Item: &Item{}}
+		var due sql.NullTime
+		err := rows.Scan(&item.ID, &item.Description, &due, &item.DueAllDay, &item.Repeat,
+			&item.Notes, &item.ListID, &item.ListName)
 		if err != nil {
 			return nil, err
 		}
+		if due.Valid {
+			item.TimeDue = due.Time
+		}
 		items = append(items, &item)
 	}
 	return items, rows.Err()
 }
 
-// AddItem adds an item with the given description to a list, returning the
-// item ID.
-func (m *SQLModel) AddItem(listID, description string) (string, error) {
-	result, err := m.db.Exec("INSERT INTO items (list_id, description) VALUES (?, ?)",
-		listID, description)
+// AddHeading adds a section heading to the end of a list, returning its ID.
+// The items after it (up to the next heading) are in its section.
+func (m *SQLModel) AddHeading(listID, name string) (string, error) {
+	return addItem(m.db, listID, &Item{Description: name, Heading: true})
+}
+
+// AddItems adds the given items (and section headings) to the end of a
+// list in order, along with the tags in their descriptions, all in one
+// transaction. Only the items' Description, Heading, ParentID, TimeDue, and
+// DueAllDay fields are used.
+func (m *SQLModel) AddItems(listID string, items []*Item) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, item := range items {
+		id, err := addItem(tx, listID, item)
+		if err != nil {
+			return err
+		}
+		if !item.Heading {
+			err = addItemTags(tx, id, parseTags(item.Description))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// execer is implemented by *sql.DB and *sql.Tx.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// addItem adds an item to the end of a list, returning its ID. Only the
+// item's Description, Heading, ParentID, TimeDue, and DueAllDay fields are
+// used.
+func addItem(db execer, listID string, item *Item) (string, error) {
+	var parentID, timeDue interface{}
+	if item.ParentID != "" {
+		parentID = item.ParentID
+	}
+	if !item.TimeDue.IsZero() {
+		timeDue = formatSQLTime(item.TimeDue)
+	}
+	result, err := db.Exec(`
+		INSERT INTO items (list_id, description, heading, parent_id, time_due, due_all_day, position)
+		VALUES (?, ?, ?, ?, ?, ?, (SELECT COALESCE(MAX(position), 0) + 1 FROM items WHERE list_id = ?))
+		`, listID, item.Description, item.Heading, parentID, timeDue, item.DueAllDay && timeDue != nil, listID)
 	if err != nil {
 		return "", err
 	}
@@ -174,46 +640,493 @@ func (m *SQLModel) AddItem(listID, description string) (string, error) {
 	return strconv.Itoa(int(id)), nil
 }
 
-// UpdateDone updates the "done" flag of the given item in a list.
-func (m *SQLModel) UpdateDone(listID, itemID string, done bool) error {
-	_, err := m.db.Exec("UPDATE items SET done = ? WHERE list_id = ? AND id = ?",
-		done, listID, itemID)
+// SetSectionCollapsed sets whether the section under the given heading in a
+// list is collapsed (its items hidden).
+func (m *SQLModel) SetSectionCollapsed(listID, headingID string, collapsed bool) error {
+	_, err := m.db.Exec("UPDATE items SET collapsed = ? WHERE list_id = ? AND id = ? AND heading",
+		collapsed, listID, headingID)
+	return err
+}
+
+// MoveItemToSection moves the given item in a list to the end of the section
+// under headingID, or to the end of the items before the first heading if
+// headingID is "". A sub-item that's moved becomes a top-level item (its own
+// sub-items move with it).
+func (m *SQLModel) MoveItemToSection(listID, itemID, headingID string) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Sections only depend on the order of top-level items, so renumber
+	// those (sub-items are only ordered relative to their siblings). Items
+	// whose parent is deleted are shown as top-level too (see nestItems).
+	rows, err := tx.Query(`
+		SELECT id, heading
+		FROM items
+		WHERE list_id = ? AND time_deleted IS NULL AND id != ? AND (
+			parent_id IS NULL OR parent_id NOT IN (
+				SELECT id FROM items WHERE list_id = ? AND time_deleted IS NULL
+			)
+		)
+		ORDER BY position, id
+		`, listID, itemID, listID)
+	if err != nil {
+		return err
+	}
+	var ids []string
+	var headings []bool
+	for rows.Next() {
+		var id string
+		var heading bool
+		err = rows.Scan(&id, &heading)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+		headings = append(headings, heading)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return rows.Err()
+	}
+
+	result, err := tx.Exec("UPDATE items SET parent_id = NULL WHERE list_id = ? AND id = ? AND NOT heading",
+		listID, itemID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n == 0 {
+		return err
+	}
+
+	// Insert before the heading of the next section
+	insertAt := len(ids)
+	inSection := headingID == ""
+	for i, id := range ids {
+		if !headings[i] {
+			continue
+		}
+		if inSection {
+			insertAt = i
+			break
+		}
+		inSection = id == headingID
+	}
+	ids = append(ids[:insertAt], append([]string{itemID}, ids[insertAt:]...)...)
+	for i, id := range ids {
+		_, err = tx.Exec("UPDATE items SET position = ? WHERE id = ?", i+1, id)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// UpdateDone updates the "done" flag of the given item in a list. When a
+// recurring item is marked done, an undone copy of it (with its notes and
+// tags) is added that's due at its next occurrence (calculated in now's
+// timezone) in the same place in the list, and the repeat rule moves to the
+// copy. The original records the copy's ID in next_id.
+func (m *SQLModel) UpdateDone(listID, itemID string, done bool, now time.Time) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE items SET done = ? WHERE list_id = ? AND id = ? AND done = ?",
+		done, listID, itemID, !done)
+	if err != nil {
+		return err
+	}
+	changed, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if done && changed > 0 {
+		var description, repeat, notes string
+		var due sql.NullTime
+		var allDay bool
+		var parentID sql.NullString
+		err = tx.QueryRow(`
+			SELECT description, time_due, due_all_day, repeat, notes, parent_id
+			FROM items
+			WHERE id = ?
+			`, itemID).Scan(&description, &due, &allDay, &repeat, &notes, &parentID)
+		if err != nil {
+			return err
+		}
+		next := nextOccurrence(repeat, due.Time, now)
+		if !next.IsZero() {
+			result, err = tx.Exec(`
+				INSERT INTO items (list_id, description, time_due, due_all_day, repeat, notes, parent_id, position)
+				VALUES (?, ?, ?, ?, ?, ?, ?, (SELECT position FROM items WHERE id = ?))
+				`, listID, description, formatSQLTime(next), allDay || !due.Valid, repeat, notes, parentID, itemID)
+			if err != nil {
+				return err
+			}
+			nextID, err := result.LastInsertId()
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec(`
+				INSERT INTO item_tags (item_id, tag_id)
+				SELECT ?, tag_id FROM item_tags WHERE item_id = ?
+				`, nextID, itemID)
+			if err != nil {
+				return err
+			}
+			_, err = tx.Exec("UPDATE items SET repeat = '', next_id = ? WHERE id = ?", nextID, itemID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// SetItemRepeat sets the repeat rule of the given item in a list ("" to stop
+// it repeating).
+func (m *SQLModel) SetItemRepeat(listID, itemID, rule string) error {
+	_, err := m.db.Exec("UPDATE items SET repeat = ? WHERE list_id = ? AND id = ?",
+		rule, listID, itemID)
 	return err
 }
 
-// DeleteItem (soft) deletes the given item in a list.
+// SetItemDue sets the due date of the given item in a list. A zero due time
+// clears the due date.
+func (m *SQLModel) SetItemDue(listID, itemID string, due time.Time, allDay bool) error {
+	var timeDue interface{}
+	if !due.IsZero() {
+		timeDue = formatSQLTime(due)
+	}
+	_, err := m.db.Exec("UPDATE items SET time_due = ?, due_all_day = ? WHERE list_id = ? AND id = ?",
+		timeDue, allDay && !due.IsZero(), listID, itemID)
+	return err
+}
+
+// SetItemNotes sets the notes of the given item in a list.
+func (m *SQLModel) SetItemNotes(listID, itemID, notes string) error {
+	_, err := m.db.Exec("UPDATE items SET notes = ? WHERE list_id = ? AND id = ?",
+		notes, listID, itemID)
+	return err
+}
+
+// addItemTags adds the given tags to an item, creating the tags if needed.
+func addItemTags(tx *sql.Tx, itemID string, tags []string) error {
+	for _, tag := range tags {
+		_, err := tx.Exec("INSERT OR IGNORE INTO tags (name) VALUES (?)", tag)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			INSERT OR IGNORE INTO item_tags (item_id, tag_id)
+			SELECT ?, id FROM tags WHERE name = ?
+			`, itemID, tag)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subtreeSQL is a common table expression that selects the IDs of an item's
+// descendants that aren't deleted. Its parameters are the list ID and the
+// item ID.
+const subtreeSQL = `
+	WITH RECURSIVE subtree(id) AS (
+		SELECT id FROM items WHERE list_id = ? AND parent_id = ? AND time_deleted IS NULL
+		UNION
+		SELECT items.id FROM items JOIN subtree ON items.parent_id = subtree.id
+		WHERE items.time_deleted IS NULL
+	)`
+
+// UpdateChildrenDone updates the "done" flag of all the descendants of the
+// given item in a list.
+func (m *SQLModel) UpdateChildrenDone(listID, itemID string, done bool) error {
+	_, err := m.db.Exec(subtreeSQL+`
+		UPDATE items SET done = ? WHERE id IN subtree
+		`, listID, itemID, done)
+	return err
+}
+
+// DeleteItem (soft) deletes the given item in a list, along with all its
+// descendants.
 func (m *SQLModel) DeleteItem(listID, itemID string) error {
-	_, err := m.db.Exec(`
+	_, err := m.db.Exec(subtreeSQL+`
 			UPDATE items
 			SET time_deleted = CURRENT_TIMESTAMP
-			WHERE list_id = ? AND id = ?
-		`, listID, itemID)
+			WHERE (list_id = ? AND id = ?) OR id IN subtree
+		`, listID, itemID, listID, itemID)
 	return err
 }
 
-// CreateSignIn creates a new sign-in and returns its secure ID.
-func (m *SQLModel) CreateSignIn() (string, error) {
+// DeleteDoneItems (soft) deletes all the done items in a list, along with
+// their descendants.
+func (m *SQLModel) DeleteDoneItems(listID string) error {
+	_, err := m.db.Exec(`
+		WITH RECURSIVE done_items(id) AS (
+			SELECT id FROM items WHERE list_id = ? AND done AND time_deleted IS NULL
+			UNION
+			SELECT items.id FROM items JOIN done_items ON items.parent_id = done_items.id
+			WHERE items.time_deleted IS NULL
+		)
+		UPDATE items
+		SET time_deleted = CURRENT_TIMESTAMP
+		WHERE id IN done_items
+		`, listID)
+	return err
+}
+
+// UncheckAllItems updates the "done" flag of all the items in a list to
+// false, except done recurring items that already have a copy for their next
+// occurrence (unchecking those would leave duplicates).
+func (m *SQLModel) UncheckAllItems(listID string) error {
+	_, err := m.db.Exec(`
+		UPDATE items SET done = FALSE
+		WHERE list_id = ? AND done AND next_id IS NULL AND time_deleted IS NULL
+		`, listID)
+	return err
+}
+
+// SignIn is an active sign-in session.
+type SignIn struct {
+	Key          string // public key for the sign-in (not the secret ID)
+	TimeCreated  time.Time
+	TimeLastSeen time.Time
+	UserAgent    string
+	IP           string
+	Username     string // user signed in as (OpenID Connect subject for OIDC sign-ins)
+	Current      bool   // true if this is the current request's sign-in
+}
+
+// CreateSignIn creates a new sign-in for username at time now from the given
+// user agent and IP address, and returns its secure ID.
+func (m *SQLModel) CreateSignIn(username, userAgent, ip string, now time.Time) (string, error) {
 	id := generateSignInToken()
-	_, err := m.db.Exec("INSERT INTO sign_ins (id) VALUES (?)", id)
+	if len(userAgent) > 255 {
+		userAgent = userAgent[:255]
+	}
+	_, err := m.db.Exec(`
+		INSERT INTO sign_ins (id, time_created, time_last_seen, user_agent, ip, username)
+		VALUES (?, ?, ?, ?, ?, ?)
+		`, hashSignInID(id), formatSQLTime(now), formatSQLTime(now), userAgent, ip, username)
 	return id, err
 }
 
+// hashSignInID returns the hex SHA-256 hash of a sign-in ID. Only the hash is
+// stored in the database, so a copy of the database can't be used to sign in.
+func hashSignInID(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateSignInToken returns a new random sign-in (or pending sign-in) ID.
+// These must be unguessable, so they use crypto/rand, unlike list IDs.
 func generateSignInToken() string {
 	b := make([]byte, 32)
-	_, err := rand.Read(b)
+	_, err := cryptorand.Read(b)
 	if err != nil { // should never fail
 		panic(err)
 	}
 	return hex.EncodeToString(b)
 }
 
-// IsSignInValid reports whether the given sign-in ID is valid.
-func (m *SQLModel) IsSignInValid(id string) (bool, error) {
+// GetSignIn fetches the sign-in with the given ID and returns it, or nil if
+// not found. The caller is responsible for checking whether it has expired.
+func (m *SQLModel) GetSignIn(id string) (*SignIn, error) {
 	row := m.db.QueryRow(`
-		SELECT 1
+		SELECT rowid, time_created, time_last_seen, user_agent, ip, username
 		FROM sign_ins
-		WHERE id = ? AND time_created > DATETIME('NOW', '-90 DAYS')
-		`, id)
+		WHERE id = ?
+		`, hashSignInID(id))
+	var signIn SignIn
+	var lastSeen sql.NullTime
+	err := row.Scan(&signIn.Key, &signIn.TimeCreated, &lastSeen, &signIn.UserAgent, &signIn.IP, &signIn.Username)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	signIn.TimeLastSeen = signIn.TimeCreated
+	if lastSeen.Valid {
+		signIn.TimeLastSeen = lastSeen.Time
+	}
+	signIn.Current = true
+	return &signIn, nil
+}
+
+// DeleteSignIn deletes the given sign-in. It's not an error if the sign-in
+// doesn't exist.
+func (m *SQLModel) DeleteSignIn(id string) error {
+	_, err := m.db.Exec("DELETE FROM sign_ins WHERE id = ?", hashSignInID(id))
+	return err
+}
+
+// TouchSignIn updates the given sign-in's last-seen time to now.
+func (m *SQLModel) TouchSignIn(id string, now time.Time) error {
+	_, err := m.db.Exec("UPDATE sign_ins SET time_last_seen = ? WHERE id = ?",
+		formatSQLTime(now), hashSignInID(id))
+	return err
+}
+
+// GetSignIns fetches the active sign-ins of the user signed in with ID
+// currentID, that is, those created after createdAfter and last seen after
+// lastSeenAfter. The current sign-in is marked as current and returned first,
+// followed by the others ordered most recently seen first.
+func (m *SQLModel) GetSignIns(currentID string, createdAfter, lastSeenAfter time.Time) ([]*SignIn, error) {
+	currentHash := hashSignInID(currentID)
+	rows, err := m.db.Query(`
+		SELECT rowid, time_created, time_last_seen, user_agent, ip, username, id = ? AS current
+		FROM sign_ins
+		WHERE username = (SELECT username FROM sign_ins WHERE id = ?)
+			AND time_created > ? AND COALESCE(time_last_seen, time_created) > ?
+		ORDER BY current DESC, COALESCE(time_last_seen, time_created) DESC
+		`, currentHash, currentHash, formatSQLTime(createdAfter), formatSQLTime(lastSeenAfter))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var signIns []*SignIn
+	for rows.Next() {
+		var signIn SignIn
+		var lastSeen sql.NullTime
+		err = rows.Scan(&signIn.Key, &signIn.TimeCreated, &lastSeen,
+			&signIn.UserAgent, &signIn.IP, &signIn.Username, &signIn.Current)
+		if err != nil {
+			return nil, err
+		}
+		signIn.TimeLastSeen = signIn.TimeCreated
+		if lastSeen.Valid {
+			signIn.TimeLastSeen = lastSeen.Time
+		}
+		signIns = append(signIns, &signIn)
+	}
+	return signIns, rows.Err()
+}
+
+// DeleteSignInByKey deletes the sign-in with the given public key, if it
+// belongs to the same user as the sign-in with ID currentID. It's not an
+// error if the sign-in doesn't exist.
+func (m *SQLModel) DeleteSignInByKey(currentID, key string) error {
+	_, err := m.db.Exec(`
+		DELETE FROM sign_ins
+		WHERE rowid = ? AND username = (SELECT username FROM sign_ins WHERE id = ?)
+		`, key, hashSignInID(currentID))
+	return err
+}
+
+// DeleteAllSignIns deletes all sign-ins of the user signed in with ID
+// currentID, signing them out everywhere.
+func (m *SQLModel) DeleteAllSignIns(currentID string) error {
+	_, err := m.db.Exec(`
+		DELETE FROM sign_ins
+		WHERE username = (SELECT username FROM sign_ins WHERE id = ?)
+		`, hashSignInID(currentID))
+	return err
+}
+
+// GetStats returns the number of lists and items (not including deleted
+// ones), and the number of active sign-ins (those created after createdAfter
+// and last seen after lastSeenAfter).
+func (m *SQLModel) GetStats(createdAfter, lastSeenAfter time.Time) (*Stats, error) {
+	row := m.db.QueryRow(`
+		SELECT
+			(SELECT COUNT(*) FROM lists WHERE time_deleted IS NULL),
+			(SELECT COUNT(*)
+			 FROM items
+			 JOIN lists ON lists.id = items.list_id
+			 WHERE items.time_deleted IS NULL AND lists.time_deleted IS NULL),
+			(SELECT COUNT(*)
+			 FROM sign_ins
+			 WHERE time_created > ? AND COALESCE(time_last_seen, time_created) > ?)
+		`, formatSQLTime(createdAfter), formatSQLTime(lastSeenAfter))
+	var stats Stats
+	err := row.Scan(&stats.Lists, &stats.Items, &stats.SignIns)
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// sqlTimeFormat is the format used to store timestamps generated in Go. It's
+// fixed width and compatible with SQLite's CURRENT_TIMESTAMP, so stored
+// timestamps compare correctly as strings.
+const sqlTimeFormat = "2006-01-02 15:04:05.000000"
+
+func formatSQLTime(t time.Time) string {
+	return t.In(time.UTC).Format(sqlTimeFormat)
+}
+
+// GetSignInFailures returns the number of recent failed sign-in attempts for
+// the given key (for example "ip:1.2.3.4"), and the time of the last one.
+func (m *SQLModel) GetSignInFailures(key string) (int, time.Time, error) {
+	row := m.db.QueryRow(`
+		SELECT failures, time_last_failure
+		FROM sign_in_failures
+		WHERE key = ?
+		`, key)
+	var failures int
+	var lastFailure time.Time
+	err := row.Scan(&failures, &lastFailure)
+	if err == sql.ErrNoRows {
+		return 0, time.Time{}, nil
+	}
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return failures, lastFailure, nil
+}
+
+// RecordSignInFailure records a failed sign-in attempt at time now for the
+// given key. Previous failures are forgotten if the last one was before
+// resetBefore.
+func (m *SQLModel) RecordSignInFailure(key string, now, resetBefore time.Time) error {
+	_, err := m.db.Exec(`
+		INSERT INTO sign_in_failures (key, failures, time_last_failure)
+		VALUES (?, 1, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN time_last_failure < ? THEN 1 ELSE failures + 1 END,
+			time_last_failure = excluded.time_last_failure
+		`, key, formatSQLTime(now), formatSQLTime(resetBefore))
+	if err != nil {
+		return err
+	}
+	// Also clean up old failures for other keys
+	_, err = m.db.Exec("DELETE FROM sign_in_failures WHERE time_last_failure < ?",
+		formatSQLTime(resetBefore))
+	return err
+}
+
+// ClearSignInFailures forgets the failed sign-in attempts for the given key.
+func (m *SQLModel) ClearSignInFailures(key string) error {
+	_, err := m.db.Exec("DELETE FROM sign_in_failures WHERE key = ?", key)
+	return err
+}
+
+// CreatePendingSignIn creates a new pending sign-in (waiting for a second
+// factor) and returns its secure ID.
+func (m *SQLModel) CreatePendingSignIn(now time.Time) (string, error) {
+	id := generateSignInToken()
+	_, err := m.db.Exec("INSERT INTO pending_sign_ins (id, time_created) VALUES (?, ?)",
+		hashSignInID(id), formatSQLTime(now))
+	return id, err
+}
+
+// IsPendingSignInValid reports whether the given pending sign-in ID exists
+// and was created after createdAfter.
+func (m *SQLModel) IsPendingSignInValid(id string, createdAfter time.Time) (bool, error) {
+	row := m.db.QueryRow(`
+		SELECT 1
+		FROM pending_sign_ins
+		WHERE id = ? AND time_created > ?
+		`, hashSignInID(id), formatSQLTime(createdAfter))
 	var dummy int
 	err := row.Scan(&dummy)
 	if err == sql.ErrNoRows {
@@ -225,9 +1138,69 @@ func (m *SQLModel) IsSignInValid(id string) (bool, error) {
 	return true, nil
 }
 
-// DeleteSignIn deletes the given sign-in. It's not an error if the sign-in
-// doesn't exist.
-func (m *SQLModel) DeleteSignIn(id string) error {
-	_, err := m.db.Exec("DELETE FROM sign_ins WHERE id = ?", id)
+// DeletePendingSignIn deletes the given pending sign-in. It's not an error if
+// it doesn't exist.
+func (m *SQLModel) DeletePendingSignIn(id string) error {
+	_, err := m.db.Exec("DELETE FROM pending_sign_ins WHERE id = ?", hashSignInID(id))
 	return err
 }
+
+// UseSecondFactor records that the given second factor (for example a TOTP
+// time step or a recovery code hash) has been used, reporting false if it
+// had already been used. Used TOTP time steps are forgotten after a day.
+func (m *SQLModel) UseSecondFactor(value string, now time.Time) (bool, error) {
+	_, err := m.db.Exec(`
+		DELETE FROM used_second_factors
+		WHERE value LIKE 'totp:%' AND time_used < ?
+		`, formatSQLTime(now.Add(-24*time.Hour)))
+	if err != nil {
+		return false, err
+	}
+	result, err := m.db.Exec(`
+		INSERT INTO used_second_factors (value, time_used)
+		VALUES (?, ?)
+		ON CONFLICT (value) DO NOTHING
+		`, value, formatSQLTime(now))
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// ProvisionUser records the given user (authenticated by a trusted proxy),
+// reporting true if this is the first time the user has been seen.
+func (m *SQLModel) ProvisionUser(username string, now time.Time) (bool, error) {
+	result, err := m.db.Exec(`
+		INSERT INTO users (username, time_created)
+		VALUES (?, ?)
+		ON CONFLICT (username) DO NOTHING
+		`, username, formatSQLTime(now))
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// EnsureSecret stores value as the named secret if it doesn't exist yet, and
+// returns the stored secret.
+func (m *SQLModel) EnsureSecret(name string, value []byte) ([]byte, error) {
+	_, err := m.db.Exec(`
+		INSERT INTO secrets (name, value)
+		VALUES (?, ?)
+		ON CONFLICT (name) DO NOTHING
+		`, name, value)
+	if err != nil {
+		return nil, err
+	}
+	var stored []byte
+	err = m.db.QueryRow("SELECT value FROM secrets WHERE name = ?", name).Scan(&stored)
+	return stored, err
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+)
+
+func (s *Server) deleteDoneItems(w http.ResponseWriter, r *http.Request) {
+	listID := r.FormValue("list-id")
+	err := s.model.DeleteDoneItems(listID)
+	if err != nil {
+		s.internalError(w, r, "deleting done items", err)
+		return
+	}
+	s.redirect(w, r, "/lists/"+listID)
+}
+
+func (s *Server) uncheckAllItems(w http.ResponseWriter, r *http.Request) {
+	listID := r.FormValue("list-id")
+	err := s.model.UncheckAllItems(listID)
+	if err != nil {
+		s.internalError(w, r, "unchecking items", err)
+		return
+	}
+	s.redirect(w, r, "/lists/"+listID)
+}
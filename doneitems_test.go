@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDoneItems(t *testing.T) {
+	l := newTestList(t, Config{}, "Chores")
+	l.addItem("Dishes", "")
+	l.addItem("Laundry", "")
+	l.addItem("Vacuum", "")
+	l.addItem("Towels", "2")
+	updateDone := func(itemIDs ...string) {
+		t.Helper()
+		for _, itemID := range itemIDs {
+			err := l.model.UpdateDone(l.id, itemID, true, time.Now())
+			if err != nil {
+				t.Fatalf("updating done: %v", err)
+			}
+		}
+	}
+
+	// No actions (or confirmation) with nothing done
+	recorder := l.get("/lists/" + l.id + "?delete-done=1")
+	ensureCode(t, recorder, http.StatusOK)
+	forms := parseForms(t, recorder.Body.String())
+	ensureString(t, forms[0].Action, "/update-done")
+	if strings.Contains(recorder.Body.String(), "?uncheck-all=1") {
+		t.Fatalf("unexpected uncheck link:\n%s", recorder.Body.String())
+	}
+
+	updateDone("1", "2")
+	recorder = l.get("/lists/" + l.id + "?uncheck-all=1")
+	forms = parseForms(t, recorder.Body.String())
+	ensureString(t, forms[0].Action, "/uncheck-all-items")
+	if !strings.Contains(recorder.Body.String(), "uncheck all 2 done items?") {
+		t.Fatalf("uncheck not confirmed:\n%s", recorder.Body.String())
+	}
+	recorder = l.post("/uncheck-all-items", url.Values{})
+	ensureRedirect(t, recorder, http.StatusFound, "/lists/"+l.id)
+	ensureString(t, l.outline(), strings.Join([]string{
+		"[ ] Dishes",
+		"[ ] Laundry",
+		"  [ ] Towels",
+		"[ ] Vacuum",
+	}, "\n"))
+
+	// Deleting done items deletes their sub-items too
+	updateDone("2", "3")
+	recorder = l.get("/lists/" + l.id + "?delete-done=1")
+	forms = parseForms(t, recorder.Body.String())
+	ensureString(t, forms[0].Action, "/delete-done-items")
+	recorder = l.post("/delete-done-items", url.Values{})
+	ensureRedirect(t, recorder, http.StatusFound, "/lists/"+l.id)
+	ensureString(t, l.outline(), "[ ] Dishes")
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+func (s *Server) setDue(w http.ResponseWriter, r *http.Request) {
+	listID := r.FormValue("list-id")
+	itemID := r.FormValue("item-id")
+	due, allDay, err := s.parseDue(r.FormValue("due-date"), r.FormValue("due-time"))
+	if err != nil {
+		s.errorPage(w, r, http.StatusBadRequest, "Invalid due date",
+			"The due date wasn't changed because the "+err.Error()+".")
+		return
+	}
+	err = s.model.SetItemDue(listID, itemID, due, allDay)
+	if err != nil {
+		s.internalError(w, r, "setting due date", err)
+		return
+	}
+	s.redirect(w, r, "/lists/"+listID)
+}
+
+// parseDue parses the due date and optional time from the add-item or due
+// date form, in the display timezone. A date without a time means the item
+// is due at any time that day (allDay is true, and due is the start of the
+// day). If date is empty, due is zero.
+func (s *Server) parseDue(date, clock string) (due time.Time, allDay bool, err error) {
+	if date == "" {
+		if clock != "" {
+			return time.Time{}, false, errors.New("due time was given without a due date")
+		}
+		return time.Time{}, false, nil
+	}
+	if clock == "" {
+		due, err = time.ParseInLocation("2006-01-02", date, s.location)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("due date %q must be in YYYY-MM-DD format", date)
+		}
+		return due, true, nil
+	}
+	due, err = time.ParseInLocation("2006-01-02 15:04", date+" "+clock, s.location)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("due date %q and time %q must be in YYYY-MM-DD and HH:MM format", date, clock)
+	}
+	return due, false, nil
+}
+
+// dueClass returns the CSS class used to highlight an item that's overdue
+// or due today (in the display timezone), or "" if neither.
+func (s *Server) dueClass(item *Item) string {
+	if item.Done || item.TimeDue.IsZero() {
+		return ""
+	}
+	now := s.now().In(s.location)
+	due := item.TimeDue.In(s.location)
+	if item.DueAllDay {
+		// Due any time that day, so it's not overdue till the day is over
+		due = due.AddDate(0, 0, 1)
+	}
+	switch {
+	case !now.Before(due):
+		return "overdue"
+	case sameDate(now, item.TimeDue.In(s.location)):
+		return "due-today"
+	default:
+		return ""
+	}
+}
+
+// sameDate reports whether a and b are on the same calendar date (in a's
+// timezone).
+func sameDate(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.In(a.Location()).Date()
+	return ay == by && am == bm && ad == bd
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDueDates(t *testing.T) {
+	server, model := newTestServer(t, nullLogger{}, Config{Timezone: "Pacific/Auckland"})
+	now := time.Date(2021, 9, 10, 12, 0, 0, 0, server.location)
+	server.now = func() time.Time { return now }
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("creating cookie jar: %v", err)
+	}
+	listID, err := model.CreateList("Chores")
+	if err != nil {
+		t.Fatalf("creating list: %v", err)
+	}
+	recorder := serve(t, server, jar, "GET", "/lists/"+listID, nil)
+	forms := parseForms(t, recorder.Body.String())
+	token := forms[0].Inputs["csrf-token"]
+	addItem := func(description, date, clock string) *http.Response {
+		t.Helper()
+		form := url.Values{}
+		form.Set("csrf-token", token)
+		form.Set("list-id", listID)
+		form.Set("description", description)
+		form.Set("due-date", date)
+		form.Set("due-time", clock)
+		return serve(t, server, jar, "POST", "/add-item", form).Result()
+	}
+
+	for _, item := range [][3]string{
+		{"no due date", "", ""},
+		{"next week", "2021-09-17", ""},
+		{"yesterday", "2021-09-09", ""},
+		{"this evening", "2021-09-10", "18:30"},
+		{"this morning", "2021-09-10", "09:00"},
+		{"today", "2021-09-10", ""},
+	} {
+		resp := addItem(item[0], item[1], item[2])
+		ensureInt(t, resp.StatusCode, http.StatusFound)
+	}
+	resp := addItem("bad", "10/09/2021", "")
+	ensureInt(t, resp.StatusCode, http.StatusBadRequest)
+	resp = addItem("bad", "", "09:00")
+	ensureInt(t, resp.StatusCode, http.StatusBadRequest)
+
+	// Due times are stored in UTC
+	list, err := model.GetList(listID)
+	if err != nil {
+		t.Fatalf("fetching list: %v", err)
+	}
+	ensureInt(t, len(list.Items), 6)
+	ensureString(t, list.Items[3].TimeDue.UTC().Format(time.RFC3339), "2021-09-10T06:30:00Z")
+	if !list.Items[5].DueAllDay || list.Items[3].DueAllDay {
+		t.Fatalf("unexpected all-day flags: %v %v", list.Items[5].DueAllDay, list.Items[3].DueAllDay)
+	}
+
+	// Items are highlighted in the display timezone
+	recorder = serve(t, server, jar, "GET", "/lists/"+listID, nil)
+	ensureCode(t, recorder, http.StatusOK)
+	dues := dueClasses(recorder.Body.String())
+	ensureString(t, strings.Join(dues, ","), strings.Join([]string{
+		"next week=due |due Fri 17 Sep",
+		"yesterday=due overdue|due Thu 9 Sep",
+		"this evening=due due-today|due Fri 10 Sep 18:30",
+		"this morning=due overdue|due Fri 10 Sep 09:00",
+		"today=due due-today|due Fri 10 Sep",
+	}, ","))
+
+	// Sort by due date puts items without due dates last
+	recorder = serve(t, server, jar, "GET", "/lists/"+listID+"?sort=due", nil)
+	dues = dueClasses(recorder.Body.String())
+	var order []string
+	for _, due := range dues {
+		order = append(order, due[:strings.Index(due, "=")])
+	}
+	ensureString(t, strings.Join(order, ","), "yesterday,today,this morning,this evening,next week")
+	links := parseLinks(t, recorder.Body.String())
+	ensureString(t, links[len(links)-3].Text, "Sort by order added")
+
+	// Due dates can be changed and cleared after adding
+	recorder = serve(t, server, jar, "GET", "/lists/"+listID+"?due=4", nil)
+	forms = parseForms(t, recorder.Body.String())
+	ensureString(t, forms[0].Action, "/set-due")
+	ensureString(t, forms[0].Inputs["due-date"], "2021-09-10")
+	ensureString(t, forms[0].Inputs["due-time"], "18:30")
+	setDue := func(itemID, date, clock string) *http.Response {
+		t.Helper()
+		form := url.Values{}
+		form.Set("csrf-token", token)
+		form.Set("list-id", listID)
+		form.Set("item-id", itemID)
+		form.Set("due-date", date)
+		form.Set("due-time", clock)
+		return serve(t, server, jar, "POST", "/set-due", form).Result()
+	}
+	resp = setDue("1", "2021-09-12", "")
+	ensureInt(t, resp.StatusCode, http.StatusFound)
+	resp = setDue("4", "", "")
+	ensureInt(t, resp.StatusCode, http.StatusFound)
+	resp = setDue("2", "2021-09-17", "7pm")
+	ensureInt(t, resp.StatusCode, http.StatusBadRequest)
+	recorder = serve(t, server, jar, "GET", "/lists/"+listID+"?due=1", nil)
+	forms = parseForms(t, recorder.Body.String())
+	ensureString(t, forms[0].Inputs["due-date"], "2021-09-12")
+	ensureString(t, forms[0].Inputs["due-time"], "")
+	dues = dueClasses(recorder.Body.String())
+	ensureString(t, strings.Join(dues, ","), strings.Join([]string{
+		"no due date=due |due Sun 12 Sep",
+		"next week=due |due Fri 17 Sep",
+		"yesterday=due overdue|due Thu 9 Sep",
+		"this morning=due overdue|due Fri 10 Sep 09:00",
+		"today=due due-today|due Fri 10 Sep",
+	}, ","))
+}
+
+var dueRegex = regexp.MustCompile(`<label[^>]*>([^<]*)</label>\s*<span class="([^"]*)"[^>]*>([^<]*)</span>`)
+
+// dueClasses returns "description=class|text" for each item with a due date.
+func dueClasses(body string) []string {
+	var dues []string
+	for _, match := range dueRegex.FindAllStringSubmatch(body, -1) {
+		dues = append(dues, match[1]+"="+match[2]+"|"+match[3])
+	}
+	return dues
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// copySuffix is added to the name of a duplicated list if no name is given.
+const copySuffix = " (copy)"
+
+// templateLists returns the lists that are templates.
+func templateLists(lists []*List) []*List {
+	var templates []*List
+	for _, list := range lists {
+		if list.Template {
+			templates = append(templates, list)
+		}
+	}
+	return templates
+}
+
+// duplicateList copies a list (and its items, all not done). It's also used
+// by the "new from template" form on the home page.
+func (s *Server) duplicateList(w http.ResponseWriter, r *http.Request) {
+	list, err := s.model.GetList(r.FormValue("list-id"))
+	if err != nil {
+		s.internalError(w, r, "fetching list", err)
+		return
+	}
+	if list == nil {
+		http.NotFound(w, r)
+		return
+	}
+	name := strings.TrimSpace(r.FormValue("name"))
+	if name == "" {
+		name = list.Name + copySuffix
+	}
+	listID, err := s.model.CopyList(list.ID, name)
+	if err != nil {
+		s.internalError(w, r, "copying list", err)
+		return
+	}
+	s.redirect(w, r, "/lists/"+listID)
+}
+
+func (s *Server) setTemplate(w http.ResponseWriter, r *http.Request) {
+	if !s.showLists {
+		// Templates are only listed on the home page if lists are shown
+		http.NotFound(w, r)
+		return
+	}
+	listID := r.FormValue("list-id")
+	template := r.FormValue("template") == "on"
+	err := s.model.SetListTemplate(listID, template)
+	if err != nil {
+		s.internalError(w, r, "updating list", err)
+		return
+	}
+	s.redirect(w, r, "/lists/"+listID)
+}
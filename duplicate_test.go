@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestDuplicateList(t *testing.T) {
+	l := newTestList(t, Config{Lists: true}, "Release")
+	l.addItem("Build", "")
+	l.addItem("## Checks", "")
+	l.addItem("Test #ci", "")
+	l.addItem("Old step", "")
+	l.addItem("Unit tests", "3")
+	for _, itemID := range []string{"1", "5"} {
+		form := url.Values{}
+		form.Set("item-id", itemID)
+		form.Set("done", "on")
+		ensureCode(t, l.post("/update-done", form), http.StatusFound)
+	}
+	form := url.Values{}
+	form.Set("item-id", "4")
+	ensureCode(t, l.post("/delete-item", form), http.StatusFound)
+
+	recorder := l.get("/lists/" + l.id + "?duplicate=1")
+	ensureCode(t, recorder, http.StatusOK)
+	forms := parseForms(t, recorder.Body.String())
+	ensureString(t, forms[0].Action, "/duplicate-list")
+	ensureString(t, forms[0].Inputs["name"], "Release (copy)")
+
+	// Items are copied not done, with sub-items, headings, and tags
+	form = url.Values{}
+	form.Set("name", "")
+	recorder = l.post("/duplicate-list", form)
+	ensureCode(t, recorder, http.StatusFound)
+	location := recorder.Result().Header.Get("Location")
+	ensureRegex(t, location, "/lists/[a-z]{10}")
+	copyID := strings.TrimPrefix(location, "/lists/")
+	ensureString(t, listOutline(t, l.model, copyID), strings.Join([]string{
+		"[ ] Build",
+		"## Checks",
+		"[ ] Test #ci",
+		"  [ ] Unit tests",
+	}, "\n"))
+	tagged, err := l.model.GetTagItems("ci")
+	if err != nil {
+		t.Fatalf("fetching tagged items: %v", err)
+	}
+	ensureInt(t, len(tagged), 2)
+
+	// The original is untouched
+	ensureString(t, l.outline(), strings.Join([]string{
+		"[x] Build",
+		"## Checks",
+		"[ ] Test #ci",
+		"  [x] Unit tests",
+	}, "\n"))
+
+	form.Set("list-id", "nosuchlist")
+	recorder = l.post("/duplicate-list", form)
+	ensureCode(t, recorder, http.StatusNotFound)
+
+	// Templates are offered on the home page
+	recorder = l.get("/")
+	ensureInt(t, len(parseForms(t, recorder.Body.String())), 1)
+	recorder = l.get("/lists/" + l.id + "?template=1")
+	forms = parseForms(t, recorder.Body.String())
+	ensureString(t, forms[0].Action, "/set-template")
+	ensureString(t, forms[0].Inputs["template"], "on")
+	form = url.Values{}
+	form.Set("template", "on")
+	recorder = l.post("/set-template", form)
+	ensureRedirect(t, recorder, http.StatusFound, "/lists/"+l.id)
+
+	recorder = l.get("/")
+	forms = parseForms(t, recorder.Body.String())
+	ensureInt(t, len(forms), 2)
+	ensureString(t, forms[1].Action, "/duplicate-list")
+	if !strings.Contains(recorder.Body.String(), `<option value="`+l.id+`">Release</option>`) {
+		t.Fatalf("template not offered:\n%s", recorder.Body.String())
+	}
+	form = url.Values{}
+	form.Set("name", "Sprint 12")
+	recorder = l.post("/duplicate-list", form)
+	ensureCode(t, recorder, http.StatusFound)
+	newID := strings.TrimPrefix(recorder.Result().Header.Get("Location"), "/lists/")
+	list, err := l.model.GetList(newID)
+	if err != nil {
+		t.Fatalf("fetching list: %v", err)
+	}
+	ensureString(t, list.Name, "Sprint 12")
+	if list.Template {
+		t.Fatalf("copy of template is a template")
+	}
+
+	form = url.Values{}
+	l.post("/set-template", form)
+	recorder = l.get("/")
+	ensureInt(t, len(parseForms(t, recorder.Body.String())), 1)
+}
+
+func TestSetTemplateNoLists(t *testing.T) {
+	l := newTestList(t, Config{}, "Release")
+	recorder := l.get("/lists/" + l.id + "?template=1")
+	body := recorder.Body.String()
+	if strings.Contains(body, "template") {
+		t.Fatalf("unexpected template option:\n%s", body)
+	}
+	form := url.Values{}
+	form.Set("template", "on")
+	recorder = l.post("/set-template", form)
+	ensureCode(t, recorder, http.StatusNotFound)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// listen creates the server's listener based on the config: a socket
+// inherited from systemd socket activation, a Unix domain socket, or
+// (by default) a TCP port. It returns the listener and a description of the
+// address for logging.
+func listen(config Config) (net.Listener, string, error) {
+	switch {
+	case config.SystemdSocket:
+		ln, err := systemdListener(os.LookupEnv, os.Getpid())
+		if err != nil {
+			return nil, "", err
+		}
+		return ln, "systemd socket " + ln.Addr().String(), nil
+	case config.UnixSocket != "":
+		mode, err := parseFileMode(config.UnixSocketMode)
+		if err != nil {
+			return nil, "", err
+		}
+		ln, err := unixListener(config.UnixSocket, mode)
+		if err != nil {
+			return nil, "", err
+		}
+		return ln, "unix:" + config.UnixSocket, nil
+	default:
+		ln, err := net.Listen("tcp", ":"+strconv.Itoa(config.Port))
+		if err != nil {
+			return nil, "", err
+		}
+		return ln, fmt.Sprintf("http://localhost:%d", config.Port), nil
+	}
+}
+
+// unixListener listens on a Unix domain socket at path, removing any stale
+// socket file first and creating the socket with permissions mode.
+func unixListener(path string, mode os.FileMode) (net.Listener, error) {
+	info, err := os.Lstat(path)
+	if err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		err = os.Remove(path)
+		if err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	// Create the socket with a umask that gives it the right permissions,
+	// so it's never accessible with looser ones, even briefly
+	var ln net.Listener
+	err = withUmask(int(^mode&0777), func() error {
+		var err error
+		ln, err = net.Listen("unix", path)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(path, mode)
+	if err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// systemdListenFDsStart is the first file descriptor passed by systemd.
+const systemdListenFDsStart = 3
+
+// systemdListener returns a listener for the first socket passed to this
+// process via systemd socket activation (see sd_listen_fds(3)).
+func systemdListener(lookupEnv func(string) (string, bool), pid int) (net.Listener, error) {
+	pidEnv, _ := lookupEnv("LISTEN_PID")
+	fdsEnv, _ := lookupEnv("LISTEN_FDS")
+	if pidEnv == "" || fdsEnv == "" {
+		return nil, errors.New("systemd socket activation: LISTEN_PID and LISTEN_FDS not set")
+	}
+	listenPID, err := strconv.Atoi(pidEnv)
+	if err != nil || listenPID != pid {
+		return nil, fmt.Errorf("systemd socket activation: LISTEN_PID %q is not this process (%d)", pidEnv, pid)
+	}
+	numFDs, err := strconv.Atoi(fdsEnv)
+	if err != nil || numFDs < 1 {
+		return nil, fmt.Errorf("systemd socket activation: invalid LISTEN_FDS %q", fdsEnv)
+	}
+
+	// Don't pass the variables on to child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	f := os.NewFile(systemdListenFDsStart, "systemd-socket")
+	defer f.Close() // net.FileListener dups the file descriptor
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("systemd socket activation: %v", err)
+	}
+	return ln, nil
+}
+
+// parseFileMode parses an octal permissions string like "0660".
+func parseFileMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, fmt.Errorf("invalid file mode %q (must be octal, for example 0660)", s)
+	}
+	return os.FileMode(mode), nil
+}
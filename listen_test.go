@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnixListener(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "simplelists.sock")
+
+	// Stale socket file is removed
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("creating stale socket: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	ln, err := unixListener(path, 0o640)
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	defer ln.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat socket: %v", err)
+	}
+	if info.Mode().Perm() != 0o640 {
+		t.Fatalf("got mode %v, want %v", info.Mode().Perm(), os.FileMode(0o640))
+	}
+
+	go http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("dialing socket: %v", err)
+	}
+	conn.Close()
+
+	// Won't remove a regular file
+	filePath := filepath.Join(t.TempDir(), "file")
+	err = os.WriteFile(filePath, nil, 0o600)
+	if err != nil {
+		t.Fatalf("writing file: %v", err)
+	}
+	_, err = unixListener(filePath, 0o660)
+	if err == nil {
+		t.Fatalf("expected error listening on regular file")
+	}
+}
+
+func TestSystemdListenerErrors(t *testing.T) {
+	tests := []struct {
+		env  map[string]string
+		want string
+	}{
+		{map[string]string{}, "systemd socket activation: LISTEN_PID and LISTEN_FDS not set"},
+		{map[string]string{"LISTEN_PID": "99", "LISTEN_FDS": "1"}, `systemd socket activation: LISTEN_PID "99" is not this process (42)`},
+		{map[string]string{"LISTEN_PID": "42", "LISTEN_FDS": "0"}, `systemd socket activation: invalid LISTEN_FDS "0"`},
+	}
+	for _, test := range tests {
+		lookupEnv := func(name string) (string, bool) {
+			value, ok := test.env[name]
+			return value, ok
+		}
+		_, err := systemdListener(lookupEnv, 42)
+		ensureString(t, errString(err), test.want)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Sign-in throttling policy. Each client IP and each username gets a few
+// free failed attempts, after which it's locked out for a period that
+// doubles with each further failure.
+const (
+	signInFreeAttempts  = 5
+	signInBaseLockout   = 30 * time.Second
+	signInMaxLockout    = time.Hour
+	signInFailureExpiry = 24 * time.Hour // failures older than this are forgotten
+)
+
+// signInLockout returns how long to lock out sign-ins after the given number
+// of consecutive failures.
+func signInLockout(failures int) time.Duration {
+	if failures < signInFreeAttempts {
+		return 0
+	}
+	lockout := signInBaseLockout
+	for i := signInFreeAttempts; i < failures && lockout < signInMaxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > signInMaxLockout {
+		lockout = signInMaxLockout
+	}
+	return lockout
+}
+
+// signInKeys returns the throttling keys for a sign-in attempt: one for the
+// client IP if it's known (see signInIP), and one for the username if it's
+// the configured one. Other usernames can't sign in anyway, and keeping
+// failures for each would let an attacker fill up the database.
+func (s *Server) signInKeys(ip, username string) []string {
+	var keys []string
+	if ip != "" {
+		keys = append(keys, "ip:"+ip)
+	}
+	if s.username != "" && strings.EqualFold(username, s.username) {
+		keys = append(keys, "user:"+strings.ToLower(s.username))
+	}
+	return keys
+}
+
+// signInIP returns the client IP to throttle sign-ins by, or "" if it's not
+// known. From a trusted proxy, it's the last address in X-Forwarded-For.
+// It's not known for requests over a Unix socket, or forwarded by a proxy
+// that isn't in trusted_proxies: every client would share the proxy's
+// address, so one attacker could lock everyone out.
+func (s *Server) signInIP(r *http.Request) string {
+	forwarded := r.Header.Get("X-Forwarded-For")
+	if forwarded != "" && s.isTrustedProxy(r) {
+		addrs := strings.Split(forwarded, ",")
+		return parseIP(addrs[len(addrs)-1])
+	}
+	if forwarded != "" || isUnixSocket(r) {
+		return ""
+	}
+	return parseIP(clientIP(r))
+}
+
+// parseIP returns s in canonical form if it's a valid IP address, otherwise
+// "".
+func parseIP(s string) string {
+	ip := net.ParseIP(strings.TrimSpace(s))
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// startSignInAttempt returns the time until which sign-ins for any of the
+// given keys are locked out (which is before now if they're not locked).
+// Attempts still in progress are counted as failures, so a burst of parallel
+// guesses can't all get past this check before the (slow) password check
+// records any failures. If not locked out, the attempt is in progress until
+// finishSignInAttempt is called.
+func (s *Server) startSignInAttempt(keys []string) (time.Time, error) {
+	s.signInMu.Lock()
+	defer s.signInMu.Unlock()
+
+	now := s.now()
+	var lockedUntil time.Time
+	for _, key := range keys {
+		failures, lastFailure, err := s.model.GetSignInFailures(key)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if inFlight := s.signInsInFlight[key]; inFlight > 0 {
+			failures += inFlight
+			lastFailure = now
+		}
+		until := lastFailure.Add(signInLockout(failures))
+		if failures > 0 && until.After(lockedUntil) {
+			lockedUntil = until
+		}
+	}
+	if now.Before(lockedUntil) {
+		return lockedUntil, nil
+	}
+	for _, key := range keys {
+		s.signInsInFlight[key]++
+	}
+	return lockedUntil, nil
+}
+
+// finishSignInAttempt ends a sign-in attempt started with
+// startSignInAttempt, recording a failure for each of its keys if failed is
+// true (it only returns an error in that case).
+func (s *Server) finishSignInAttempt(keys []string, failed bool) error {
+	s.signInMu.Lock()
+	defer s.signInMu.Unlock()
+
+	for _, key := range keys {
+		s.signInsInFlight[key]--
+		if s.signInsInFlight[key] <= 0 {
+			delete(s.signInsInFlight, key)
+		}
+	}
+	if !failed {
+		return nil
+	}
+	return s.recordSignInFailure(keys)
+}
+
+// recordSignInFailure records a failed sign-in attempt for each of the
+// given keys.
+func (s *Server) recordSignInFailure(keys []string) error {
+	now := s.now()
+	for _, key := range keys {
+		err := s.model.RecordSignInFailure(key, now, now.Add(-signInFailureExpiry))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clearSignInFailures forgets the failed sign-in attempts for each of the
+// given keys (after a successful sign-in).
+func (s *Server) clearSignInFailures(keys []string) error {
+	for _, key := range keys {
+		err := s.model.ClearSignInFailures(key)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
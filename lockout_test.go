@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSignInLockout(t *testing.T) {
+	hash, err := GeneratePasswordHash("password")
+	if err != nil {
+		t.Fatalf("generating password hash: %v", err)
+	}
+	config := Config{Username: "bob", PassHash: hash}
+	server, model := newTestServer(t, nullLogger{}, config)
+	now := time.Date(2021, 9, 10, 12, 0, 0, 0, time.UTC)
+	server.now = func() time.Time { return now }
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("creating cookie jar: %v", err)
+	}
+
+	signIn := func(server *Server, username, password string) string {
+		t.Helper()
+		recorder := serve(t, server, jar, "GET", "/", nil)
+		forms := parseForms(t, recorder.Body.String())
+		form := url.Values{}
+		form.Set("csrf-token", forms[0].Inputs["csrf-token"])
+		form.Set("username", username)
+		form.Set("password", password)
+		recorder = serve(t, server, jar, "POST", "/sign-in", form)
+		ensureCode(t, recorder, http.StatusFound)
+		return recorder.Result().Header.Get("Location")
+	}
+
+	// A few failures are allowed
+	for i := 0; i < signInFreeAttempts; i++ {
+		ensureString(t, signIn(server, "bob", "wrong"), "/?error=sign-in&return-url=%2F")
+	}
+
+	// Then even the correct password is locked out
+	ensureString(t, signIn(server, "bob", "password"), "/?error=locked&return-url=%2F")
+	now = now.Add(signInBaseLockout - time.Second)
+	ensureString(t, signIn(server, "BOB", "password"), "/?error=locked&return-url=%2F")
+
+	// Another failure after the lockout expires doubles the lockout
+	now = now.Add(2 * time.Second)
+	ensureString(t, signIn(server, "bob", "wrong"), "/?error=sign-in&return-url=%2F")
+	now = now.Add(2*signInBaseLockout - time.Second)
+	ensureString(t, signIn(server, "bob", "password"), "/?error=locked&return-url=%2F")
+
+	// Lockout is persisted in the database, so survives a restart
+	restarted, err := NewServer(model, nullLogger{}, config)
+	if err != nil {
+		t.Fatalf("creating server: %v", err)
+	}
+	restarted.now = server.now
+	ensureString(t, signIn(restarted, "bob", "password"), "/?error=locked&return-url=%2F")
+
+	// Once lockout expires, sign in succeeds and failures are reset
+	now = now.Add(2 * time.Second)
+	ensureString(t, signIn(restarted, "bob", "password"), "/")
+	for _, key := range server.signInKeys("", "bob") {
+		failures, _, err := model.GetSignInFailures(key)
+		if err != nil {
+			t.Fatalf("getting sign in failures: %v", err)
+		}
+		ensureInt(t, failures, 0)
+	}
+
+	// Failures are forgotten after a while
+	for i := 0; i < signInFreeAttempts-1; i++ {
+		signIn(server, "bob", "wrong")
+	}
+	now = now.Add(signInFailureExpiry + time.Second)
+	signIn(server, "bob", "wrong")
+	failures, _, err := model.GetSignInFailures("user:bob")
+	if err != nil {
+		t.Fatalf("getting sign in failures: %v", err)
+	}
+	ensureInt(t, failures, 1)
+
+	// Unknown usernames don't get their own failure counts
+	signIn(server, "mallory", "wrong")
+	failures, _, err = model.GetSignInFailures("user:mallory")
+	if err != nil {
+		t.Fatalf("getting sign in failures: %v", err)
+	}
+	ensureInt(t, failures, 0)
+}
+
+func TestSignInLockoutParallel(t *testing.T) {
+	hash, err := GeneratePasswordHash("password")
+	if err != nil {
+		t.Fatalf("generating password hash: %v", err)
+	}
+	server, model := newTestServer(t, nullLogger{}, Config{Username: "bob", PassHash: hash})
+	keys := server.signInKeys("192.0.2.1", "bob")
+
+	// Attempts in progress count as failures, so a parallel burst of
+	// guesses is locked out before any of them have finished
+	for i := 0; i < signInFreeAttempts; i++ {
+		lockedUntil, err := server.startSignInAttempt(keys)
+		if err != nil {
+			t.Fatalf("starting sign in attempt: %v", err)
+		}
+		if server.now().Before(lockedUntil) {
+			t.Fatalf("attempt %d locked out, want allowed", i+1)
+		}
+	}
+	lockedUntil, err := server.startSignInAttempt(keys)
+	if err != nil {
+		t.Fatalf("starting sign in attempt: %v", err)
+	}
+	if !server.now().Before(lockedUntil) {
+		t.Fatalf("attempt %d allowed, want locked out", signInFreeAttempts+1)
+	}
+
+	// Finished attempts are recorded as failures (if they failed)
+	for i := 0; i < signInFreeAttempts; i++ {
+		err := server.finishSignInAttempt(keys, i > 0)
+		if err != nil {
+			t.Fatalf("finishing sign in attempt: %v", err)
+		}
+	}
+	ensureInt(t, len(server.signInsInFlight), 0)
+	failures, _, err := model.GetSignInFailures("ip:192.0.2.1")
+	if err != nil {
+		t.Fatalf("getting sign in failures: %v", err)
+	}
+	ensureInt(t, failures, signInFreeAttempts-1)
+}
+
+func TestSignInIP(t *testing.T) {
+	server, _ := newTestServer(t, nullLogger{}, Config{TrustedProxies: "10.0.0.1, unix"})
+	unixAddr := &net.UnixAddr{Name: "/run/simplelists.sock", Net: "unix"}
+	tests := []struct {
+		remoteAddr string
+		forwarded  string
+		unix       bool
+		ip         string
+	}{
+		{"192.0.2.1:1234", "", false, "192.0.2.1"},
+		{"[2001:db8::1]:1234", "", false, "2001:db8::1"},
+		{"10.0.0.1:1234", "198.51.100.7, 192.0.2.1", false, "192.0.2.1"},
+		{"10.0.0.1:1234", "junk", false, ""},
+		{"10.0.0.2:1234", "192.0.2.1", false, ""}, // untrusted proxy
+		{"@", "", true, ""},
+		{"@", "192.0.2.1", true, "192.0.2.1"},
+		{"", "", false, ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("POST", "http://localhost/sign-in", nil)
+		r.RemoteAddr = test.remoteAddr
+		if test.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", test.forwarded)
+		}
+		if test.unix {
+			r = r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey, unixAddr))
+		}
+		ip := server.signInIP(r)
+		if ip != test.ip {
+			t.Errorf("signInIP(%q, %q, unix %v): got %q, want %q",
+				test.remoteAddr, test.forwarded, test.unix, ip, test.ip)
+		}
+	}
+}
+
+func TestSignInLockoutBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		lockout  time.Duration
+	}{
+		{0, 0},
+		{signInFreeAttempts - 1, 0},
+		{signInFreeAttempts, signInBaseLockout},
+		{signInFreeAttempts + 1, 2 * signInBaseLockout},
+		{signInFreeAttempts + 2, 4 * signInBaseLockout},
+		{signInFreeAttempts + 100, signInMaxLockout},
+	}
+	for _, test := range tests {
+		lockout := signInLockout(test.failures)
+		if lockout != test.lockout {
+			t.Errorf("signInLockout(%d): got %v, want %v", test.failures, lockout, test.lockout)
+		}
+	}
+}
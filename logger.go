@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Logger is the logger interface used by the server. The keyvals arguments
+// are alternating key, value pairs that are added to the log entry as fields.
+type Logger interface {
+	Log(level LogLevel, msg string, keyvals ...interface{})
+}
+
+// LogLevel is the severity of a log entry.
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+// String returns the level's name, for example "info".
+func (l LogLevel) String() string {
+	if l < 0 || int(l) >= len(levelNames) {
+		return "level(" + strconv.Itoa(int(l)) + ")"
+	}
+	return levelNames[l]
+}
+
+// ParseLogLevel parses a level name such as "info" or "error".
+func ParseLogLevel(s string) (LogLevel, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return LogLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("invalid log level %q (must be debug, info, warn, or error)", s)
+}
+
+// Log formats supported by NewLogger.
+const (
+	LogFormatLogfmt = "logfmt"
+	LogFormatJSON   = "json"
+)
+
+// StructuredLogger is a Logger that writes one entry per line in logfmt or
+// JSON format, ignoring entries below its minimum level.
+type StructuredLogger struct {
+	mu       sync.Mutex
+	w        io.Writer
+	json     bool
+	minLevel LogLevel
+	now      func() time.Time
+}
+
+// NewLogger creates a new structured logger that writes to w in the given
+// format (LogFormatLogfmt or LogFormatJSON), logging entries at minLevel and
+// above.
+func NewLogger(w io.Writer, format string, minLevel LogLevel) (*StructuredLogger, error) {
+	if format != LogFormatLogfmt && format != LogFormatJSON {
+		return nil, fmt.Errorf("invalid log format %q (must be %s or %s)",
+			format, LogFormatLogfmt, LogFormatJSON)
+	}
+	l := &StructuredLogger{
+		w:        w,
+		json:     format == LogFormatJSON,
+		minLevel: minLevel,
+		now:      time.Now,
+	}
+	return l, nil
+}
+
+// Log implements the Logger interface.
+func (l *StructuredLogger) Log(level LogLevel, msg string, keyvals ...interface{}) {
+	if level < l.minLevel {
+		return
+	}
+	fields := []interface{}{
+		"time", l.now().UTC().Format("2006-01-02T15:04:05.000Z07:00"),
+		"level", level.String(),
+		"msg", msg,
+	}
+	fields = append(fields, keyvals...)
+	if len(fields)%2 != 0 {
+		fields = append(fields, "(MISSING)")
+	}
+
+	var buf bytes.Buffer
+	if l.json {
+		writeJSON(&buf, fields)
+	} else {
+		writeLogfmt(&buf, fields)
+	}
+	buf.WriteByte('\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(buf.Bytes())
+}
+
+func writeJSON(buf *bytes.Buffer, fields []interface{}) {
+	buf.WriteByte('{')
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(fmt.Sprint(fields[i]))
+		buf.Write(key)
+		buf.WriteByte(':')
+		var value interface{}
+		switch v := fields[i+1].(type) {
+		case error:
+			value = v.Error()
+		case time.Duration:
+			value = v.String()
+		case fmt.Stringer:
+			value = v.String()
+		default:
+			value = v
+		}
+		b, err := json.Marshal(value)
+		if err != nil {
+			b, _ = json.Marshal(fmt.Sprint(value))
+		}
+		buf.Write(b)
+	}
+	buf.WriteByte('}')
+}
+
+func writeLogfmt(buf *bytes.Buffer, fields []interface{}) {
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(fmt.Sprint(fields[i]))
+		buf.WriteByte('=')
+		value := fmt.Sprint(fields[i+1])
+		if needsQuoting(value) {
+			buf.WriteString(strconv.Quote(value))
+		} else {
+			buf.WriteString(value)
+		}
+	}
+}
+
+func needsQuoting(s string) bool {
+	if s == "" {
+		return true
+	}
+	for _, c := range s {
+		if c <= ' ' || c == '=' || c == '"' || c == utf8.RuneError {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"net/http"
+	"net/http/cookiejar"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoggerFormats(t *testing.T) {
+	now := func() time.Time { return time.Date(2021, 9, 10, 1, 2, 3, 4000000, time.UTC) }
+
+	var buf bytes.Buffer
+	logger, err := NewLogger(&buf, LogFormatLogfmt, LevelInfo)
+	if err != nil {
+		t.Fatalf("creating logger: %v", err)
+	}
+	logger.now = now
+	logger.Log(LevelDebug, "hidden")
+	logger.Log(LevelInfo, "request", "path", "/lists/abc", "status", 200, "user", "")
+	logger.Log(LevelError, "error fetching list", "error", sql.ErrNoRows)
+	ensureString(t, buf.String(),
+		`time=2021-09-10T01:02:03.004Z level=info msg=request path=/lists/abc status=200 user=""`+"\n"+
+			`time=2021-09-10T01:02:03.004Z level=error msg="error fetching list" error="sql: no rows in result set"`+"\n")
+
+	buf.Reset()
+	logger, err = NewLogger(&buf, LogFormatJSON, LevelDebug)
+	if err != nil {
+		t.Fatalf("creating logger: %v", err)
+	}
+	logger.now = now
+	logger.Log(LevelDebug, "request", "status", 200, "duration_ms", 1.5, "level_name", LevelWarn)
+	ensureString(t, buf.String(),
+		`{"time":"2021-09-10T01:02:03.004Z","level":"debug","msg":"request","status":200,"duration_ms":1.5,"level_name":"warn"}`+"\n")
+
+	_, err = NewLogger(&buf, "xml", LevelDebug)
+	if err == nil {
+		t.Fatalf("expected error for invalid format")
+	}
+	_, err = ParseLogLevel("loud")
+	if err == nil {
+		t.Fatalf("expected error for invalid level")
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := NewLogger(&buf, LogFormatLogfmt, LevelInfo)
+	if err != nil {
+		t.Fatalf("creating logger: %v", err)
+	}
+	server, _ := newTestServer(t, logger, Config{Lists: true})
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("creating cookie jar: %v", err)
+	}
+
+	recorder := serve(t, server, jar, "GET", "/lists/nonexistent", nil)
+	ensureCode(t, recorder, http.StatusNotFound)
+	requestID := recorder.Result().Header.Get("X-Request-ID")
+	ensureRegex(t, requestID, "[0-9a-f]{16}")
+
+	line := buf.String()
+	for _, field := range []string{
+		"level=info", "msg=request", "method=GET", "path=/lists/nonexistent", "status=404",
+		"bytes=19", "request_id=" + requestID,
+	} {
+		if !strings.Contains(line, " "+field+" ") {
+			t.Errorf("access log %q doesn't contain %q", line, field)
+		}
+	}
+}
@@ -9,36 +9,42 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"strconv"
+	"strings"
 
 	"golang.org/x/term"
 	_ "modernc.org/sqlite"
 )
 
 func main() {
-	// Config defaults
-	port := 8080
-	dbPath := "simplelists.sqlite"
-	showLists := false
-	timezone := ""
-	username := ""
-
 	flag.Usage = func() {
-		fmt.Fprintf(flag.CommandLine.Output(), `Usage: simplelists [options]
+		fmt.Fprintf(flag.CommandLine.Output(), `Usage: simplelists [options] [command]
+
+Commands:
+  config check          validate and print config (with secrets redacted)
 
 Options:
-  -genpass              create password hash (instead of running server)
-
-Environment variables:
-  PORT                  HTTP port to listen on (default %d)
-  SIMPLELISTS_DB        path to SQLite 3 database (default %q)
-  SIMPLELISTS_LISTS     show lists on homepage (if set to 1 or "true")
-  SIMPLELISTS_PASSHASH  password hash (required if username is set)
-  SIMPLELISTS_TIMEZONE  IANA timezone name (defaults to local timezone)
-  SIMPLELISTS_USERNAME  optional username to access site
-`, port, dbPath)
+  -config path          path to JSON config file (or set SIMPLELISTS_CONFIG)
+  -genpass              create argon2id password hash (instead of running
+                        server), tuned with:
+    -genpass-memory MiB   memory to use (default %d)
+    -genpass-iterations n number of iterations (default %d)
+    -genpass-threads n    number of threads (default %d)
+  -gentotp              create TOTP secret and recovery codes (instead of
+                        running server)
+
+Settings (flag, environment variable, config file key):
+`, DefaultArgon2Params.Memory/1024, DefaultArgon2Params.Iterations, DefaultArgon2Params.Parallelism)
+		printSettingsUsage(flag.CommandLine.Output())
+		fmt.Fprintf(flag.CommandLine.Output(), `
+Flags override the config file, which overrides environment variables.
+`)
 	}
 	genPass := flag.Bool("genpass", false, "-")
+	genPassMemory := flag.Uint("genpass-memory", uint(DefaultArgon2Params.Memory/1024), "-")
+	genPassIterations := flag.Uint("genpass-iterations", uint(DefaultArgon2Params.Iterations), "-")
+	genPassThreads := flag.Uint("genpass-threads", uint(DefaultArgon2Params.Parallelism), "-")
+	genTOTP := flag.Bool("gentotp", false, "-")
+	configFlags := AddConfigFlags(flag.CommandLine)
 	flag.Parse()
 
 	if *genPass {
@@ -50,54 +56,78 @@ Environment variables:
 			exitOnError(err)
 			password = string(b)
 		}
-		hash, err := GeneratePasswordHash(password)
+		if *genPassThreads > 255 {
+			exitOnError(fmt.Errorf("-genpass-threads must be at most 255"))
+		}
+		params := DefaultArgon2Params
+		params.Memory = uint32(*genPassMemory * 1024)
+		params.Iterations = uint32(*genPassIterations)
+		params.Parallelism = uint8(*genPassThreads)
+		if params.weakerThan(DefaultArgon2Params) {
+			fmt.Fprintln(os.Stderr, "warning: parameters are weaker than the defaults")
+		}
+		hash, err := GenerateArgon2Hash(password, params)
 		exitOnError(err)
 		fmt.Println(hash)
 		return
 	}
 
-	// Parse config from environment variables
-	var err error
-	if portEnv, ok := os.LookupEnv("PORT"); ok {
-		port, err = strconv.Atoi(portEnv)
-		if err != nil {
-			exitOnError(err)
+	config, err := LoadConfig(configFlags, os.LookupEnv)
+	exitOnError(err)
+
+	if *genTOTP {
+		secret := GenerateTOTPSecret()
+		codes, hashes := GenerateRecoveryCodes()
+		fmt.Printf("TOTP secret (set SIMPLELISTS_TOTP_SECRET):\n  %s\n\n", secret)
+		fmt.Printf("Add to your authenticator app using this URI (or the secret above):\n  %s\n\n",
+			TOTPURI(secret, config.Username))
+		fmt.Printf("Recovery codes (store these safely, each can be used once):\n")
+		for _, code := range codes {
+			fmt.Printf("  %s\n", code)
 		}
-	}
-	if dbEnv, ok := os.LookupEnv("SIMPLELISTS_DB"); ok {
-		dbPath = dbEnv
-	}
-	if listsEnv, ok := os.LookupEnv("SIMPLELISTS_LISTS"); ok {
-		showLists = listsEnv == "1" || listsEnv == "true"
-	}
-	if timezoneEnv, ok := os.LookupEnv("SIMPLELISTS_TIMEZONE"); ok {
-		timezone = timezoneEnv
-	}
-	if usernameEnv, ok := os.LookupEnv("SIMPLELISTS_USERNAME"); ok {
-		username = usernameEnv
+		fmt.Printf("\nRecovery code hashes (set SIMPLELISTS_TOTP_RECOVERY):\n  %s\n",
+			strings.Join(hashes, ","))
+		return
 	}
 
-	var passwordHash string
-	if username != "" {
-		passwordHash = os.Getenv("SIMPLELISTS_PASSHASH")
-		if passwordHash == "" {
-			log.Fatal("SIMPLELISTS_PASSHASH must be set if username is set")
-		}
-		err := CheckPasswordHash(passwordHash)
+	switch {
+	case flag.NArg() == 0:
+		// Run server (below)
+	case flag.NArg() == 2 && flag.Arg(0) == "config" && flag.Arg(1) == "check":
+		err := config.Print(os.Stdout)
+		exitOnError(err)
+		err = config.Validate()
 		exitOnError(err)
+		fmt.Fprintln(os.Stderr, "config is valid")
+		return
+	default:
+		flag.Usage()
+		os.Exit(2)
 	}
 
-	db, err := sql.Open("sqlite", dbPath)
+	err = config.Validate()
+	exitOnError(err)
+
+	logLevel, err := ParseLogLevel(config.LogLevel)
+	exitOnError(err)
+	logger, err := NewLogger(os.Stderr, config.LogFormat, logLevel)
+	exitOnError(err)
+
+	db, err := sql.Open("sqlite", config.DB)
 	exitOnError(err)
 	model, err := NewSQLModel(db)
 	exitOnError(err)
-	server, err := NewServer(model, log.Default(), timezone, username, passwordHash, showLists)
+	server, err := NewServer(model, logger, config)
+	exitOnError(err)
+
+	listener, address, err := listen(config)
 	exitOnError(err)
 
-	log.Printf("config: port=%d db=%q lists=%v timezone=%q username=%q",
-		port, dbPath, showLists, timezone, username)
-	log.Printf("listening on http://localhost:%d", port)
-	err = http.ListenAndServe(":"+strconv.Itoa(port), server)
+	logger.Log(LevelInfo, "config", "port", config.Port, "db", config.DB, "lists", config.Lists,
+		"timezone", config.Timezone, "username", config.Username,
+		"log_format", config.LogFormat, "log_level", config.LogLevel)
+	logger.Log(LevelInfo, "listening", "address", address)
+	err = http.Serve(listener, server)
 	exitOnError(err)
 }
 
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// durationBuckets are the upper bounds (in seconds) of the latency
+// histogram buckets.
+var durationBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// metrics records the server's metrics and writes them out in Prometheus
+// text exposition format. It's safe for concurrent use.
+type metrics struct {
+	mu            sync.Mutex
+	requests      map[requestKey]*histogram
+	modelCalls    map[string]*histogram
+	failedSignIns int
+	startTime     time.Time
+}
+
+type requestKey struct {
+	route  string
+	status int
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests:   make(map[requestKey]*histogram),
+		modelCalls: make(map[string]*histogram),
+		startTime:  time.Now(),
+	}
+}
+
+// observeRequest records an HTTP request to the given route (mux pattern).
+func (m *metrics) observeRequest(route string, status int, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := requestKey{route, status}
+	h, ok := m.requests[key]
+	if !ok {
+		h = newHistogram()
+		m.requests[key] = h
+	}
+	h.observe(duration.Seconds())
+}
+
+// observeModelCall records a call to the given model method.
+func (m *metrics) observeModelCall(method string, duration time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.modelCalls[method]
+	if !ok {
+		h = newHistogram()
+		m.modelCalls[method] = h
+	}
+	h.observe(duration.Seconds())
+}
+
+// incFailedSignIns increments the count of failed sign-in attempts.
+func (m *metrics) incFailedSignIns() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failedSignIns++
+}
+
+// write writes all metrics to w in Prometheus text format, along with the
+// given database stats.
+func (m *metrics) write(w io.Writer, stats *Stats) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var requestKeys []requestKey
+	for key := range m.requests {
+		requestKeys = append(requestKeys, key)
+	}
+	sort.Slice(requestKeys, func(i, j int) bool {
+		if requestKeys[i].route != requestKeys[j].route {
+			return requestKeys[i].route < requestKeys[j].route
+		}
+		return requestKeys[i].status < requestKeys[j].status
+	})
+
+	writeHeader(w, "simplelists_http_requests_total", "counter", "Total number of HTTP requests by route and status.")
+	for _, key := range requestKeys {
+		labels := formatLabels("route", key.route, "status", strconv.Itoa(key.status))
+		fmt.Fprintf(w, "simplelists_http_requests_total%s %d\n", labels, m.requests[key].count)
+	}
+
+	writeHeader(w, "simplelists_http_request_duration_seconds", "histogram", "HTTP request latency by route and status.")
+	for _, key := range requestKeys {
+		m.requests[key].write(w, "simplelists_http_request_duration_seconds",
+			"route", key.route, "status", strconv.Itoa(key.status))
+	}
+
+	var methods []string
+	for method := range m.modelCalls {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	writeHeader(w, "simplelists_model_call_duration_seconds", "histogram", "Database model call latency by method.")
+	for _, method := range methods {
+		m.modelCalls[method].write(w, "simplelists_model_call_duration_seconds", "method", method)
+	}
+
+	writeHeader(w, "simplelists_failed_sign_ins_total", "counter", "Total number of failed sign-in attempts.")
+	fmt.Fprintf(w, "simplelists_failed_sign_ins_total %d\n", m.failedSignIns)
+
+	if stats != nil {
+		writeHeader(w, "simplelists_lists", "gauge", "Number of lists (not including deleted lists).")
+		fmt.Fprintf(w, "simplelists_lists %d\n", stats.Lists)
+		writeHeader(w, "simplelists_items", "gauge", "Number of list items (not including deleted items).")
+		fmt.Fprintf(w, "simplelists_items %d\n", stats.Items)
+		writeHeader(w, "simplelists_active_sign_ins", "gauge", "Number of active (unexpired) sign-ins.")
+		fmt.Fprintf(w, "simplelists_active_sign_ins %d\n", stats.SignIns)
+	}
+
+	writeHeader(w, "simplelists_start_time_seconds", "gauge", "Start time of the server since Unix epoch in seconds.")
+	fmt.Fprintf(w, "simplelists_start_time_seconds %d\n", m.startTime.Unix())
+}
+
+func writeHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// formatLabels formats the given name, value pairs as a Prometheus label set
+// like {name1="value1",name2="value2"}.
+func formatLabels(nameValues ...string) string {
+	if len(nameValues) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i < len(nameValues); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(nameValues[i])
+		b.WriteString(`="`)
+		b.WriteString(labelValueReplacer.Replace(nameValues[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// histogram is a simple cumulative histogram using durationBuckets.
+type histogram struct {
+	counts []int // counts[i] is the number of observations <= durationBuckets[i]
+	count  int
+	sum    float64
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]int, len(durationBuckets))}
+}
+
+func (h *histogram) observe(value float64) {
+	for i, bound := range durationBuckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+func (h *histogram) write(w io.Writer, name string, nameValues ...string) {
+	for i, bound := range durationBuckets {
+		labels := formatLabels(append(nameValues, "le", strconv.FormatFloat(bound, 'g', -1, 64))...)
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, labels, h.counts[i])
+	}
+	labels := formatLabels(append(nameValues, "le", "+Inf")...)
+	fmt.Fprintf(w, "%s_bucket%s %d\n", name, labels, h.count)
+	labels = formatLabels(nameValues...)
+	fmt.Fprintf(w, "%s_sum%s %g\n", name, labels, h.sum)
+	fmt.Fprintf(w, "%s_count%s %d\n", name, labels, h.count)
+}
+
+// showMetrics serves the metrics in Prometheus text format. If a metrics
+// token is configured, the request must include it as a bearer token.
+func (s *Server) showMetrics(w http.ResponseWriter, r *http.Request) {
+	if s.metricsToken != "" {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.metricsToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "401 unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	stats, err := s.model.GetStats(s.signInCutoffs())
+	if err != nil {
+		// Still show the in-memory metrics if fetching stats fails
+		s.logger.Log(LevelError, "error fetching stats", "error", err,
+			"request_id", getRequestInfo(r).id)
+		stats = nil
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.metrics.write(w, stats)
+}
+
+// metricsModel wraps a Model, recording the latency of each call.
+type metricsModel struct {
+	model   Model
+	metrics *metrics
+}
+
+func (m *metricsModel) observe(method string, startTime time.Time) {
+	m.metrics.observeModelCall(method, time.Since(startTime))
+}
+
+func (m *metricsModel) GetLists() ([]*List, error) {
+	defer m.observe("GetLists", time.Now())
+	return m.model.GetLists()
+}
+
+func (m *metricsModel) CreateList(name string) (string, error) {
+	defer m.observe("CreateList", time.Now())
+	return m.model.CreateList(name)
+}
+
+func (m *metricsModel) DeleteList(id string) error {
+	defer m.observe("DeleteList", time.Now())
+	return m.model.DeleteList(id)
+}
+
+func (m *metricsModel) CopyList(id, name string) (string, error) {
+	defer m.observe("CopyList", time.Now())
+	return m.model.CopyList(id, name)
+}
+
+func (m *metricsModel) SetListTemplate(id string, template bool) error {
+	defer m.observe("SetListTemplate", time.Now())
+	return m.model.SetListTemplate(id, template)
+}
+
+func (m *metricsModel) GetList(id string) (*List, error) {
+	defer m.observe("GetList", time.Now())
+	return m.model.GetList(id)
+}
+
+func (m *metricsModel) UpdateDone(listID, itemID string, done bool, now time.Time) error {
+	defer m.observe("UpdateDone", time.Now())
+	return m.model.UpdateDone(listID, itemID, done, now)
+}
+
+func (m *metricsModel) SetItemDue(listID, itemID string, due time.Time, allDay bool) error {
+	defer m.observe("SetItemDue", time.Now())
+	return m.model.SetItemDue(listID, itemID, due, allDay)
+}
+
+func (m *metricsModel) SetItemRepeat(listID, itemID, rule string) error {
+	defer m.observe("SetItemRepeat", time.Now())
+	return m.model.SetItemRepeat(listID, itemID, rule)
+}
+
+func (m *metricsModel) SetItemNotes(listID, itemID, notes string) error {
+	defer m.observe("SetItemNotes", time.Now())
+	return m.model.SetItemNotes(listID, itemID, notes)
+}
+
+func (m *metricsModel) AddHeading(listID, name string) (string, error) {
+	defer m.observe("AddHeading", time.Now())
+	return m.model.AddHeading(listID, name)
+}
+
+func (m *metricsModel) AddItems(listID string, items []*Item) error {
+	defer m.observe("AddItems", time.Now())
+	return m.model.AddItems(listID, items)
+}
+
+func (m *metricsModel) SetSectionCollapsed(listID, headingID string, collapsed bool) error {
+	defer m.observe("SetSectionCollapsed", time.Now())
+	return m.model.SetSectionCollapsed(listID, headingID, collapsed)
+}
+
+func (m *metricsModel) MoveItemToSection(listID, itemID, headingID string) error {
+	defer m.observe("MoveItemToSection", time.Now())
+	return m.model.MoveItemToSection(listID, itemID, headingID)
+}
+
+func (m *metricsModel) UpdateChildrenDone(listID, itemID string, done bool) error {
+	defer m.observe("UpdateChildrenDone", time.Now())
+	return m.model.UpdateChildrenDone(listID, itemID, done)
+}
+
+func (m *metricsModel) GetDueItems(before time.Time) ([]*ListItem, error) {
+	defer m.observe("GetDueItems", time.Now())
+	return m.model.GetDueItems(before)
+}
+
+func (m *metricsModel) GetTagItems(tag string) ([]*ListItem, error) {
+	defer m.observe("GetTagItems", time.Now())
+	return m.model.GetTagItems(tag)
+}
+
+func (m *metricsModel) DeleteItem(listID, itemID string) error {
+	defer m.observe("DeleteItem", time.Now())
+	return m.model.DeleteItem(listID, itemID)
+}
+
+func (m *metricsModel) DeleteDoneItems(listID string) error {
+	defer m.observe("DeleteDoneItems", time.Now())
+	return m.model.DeleteDoneItems(listID)
+}
+
+func (m *metricsModel) UncheckAllItems(listID string) error {
+	defer m.observe("UncheckAllItems", time.Now())
+	return m.model.UncheckAllItems(listID)
+}
+
+func (m *metricsModel) CreateSignIn(username, userAgent, ip string, now time.Time) (string, error) {
+	defer m.observe("CreateSignIn", time.Now())
+	return m.model.CreateSignIn(username, userAgent, ip, now)
+}
+
+func (m *metricsModel) GetSignIn(id string) (*SignIn, error) {
+	defer m.observe("GetSignIn", time.Now())
+	return m.model.GetSignIn(id)
+}
+
+func (m *metricsModel) DeleteSignIn(id string) error {
+	defer m.observe("DeleteSignIn", time.Now())
+	return m.model.DeleteSignIn(id)
+}
+
+func (m *metricsModel) TouchSignIn(id string, now time.Time) error {
+	defer m.observe("TouchSignIn", time.Now())
+	return m.model.TouchSignIn(id, now)
+}
+
+func (m *metricsModel) GetSignIns(currentID string, createdAfter, lastSeenAfter time.Time) ([]*SignIn, error) {
+	defer m.observe("GetSignIns", time.Now())
+	return m.model.GetSignIns(currentID, createdAfter, lastSeenAfter)
+}
+
+func (m *metricsModel) DeleteSignInByKey(currentID, key string) error {
+	defer m.observe("DeleteSignInByKey", time.Now())
+	return m.model.DeleteSignInByKey(currentID, key)
+}
+
+func (m *metricsModel) DeleteAllSignIns(currentID string) error {
+	defer m.observe("DeleteAllSignIns", time.Now())
+	return m.model.DeleteAllSignIns(currentID)
+}
+
+func (m *metricsModel) GetStats(createdAfter, lastSeenAfter time.Time) (*Stats, error) {
+	defer m.observe("GetStats", time.Now())
+	return m.model.GetStats(createdAfter, lastSeenAfter)
+}
+
+func (m *metricsModel) GetSignInFailures(key string) (int, time.Time, error) {
+	defer m.observe("GetSignInFailures", time.Now())
+	return m.model.GetSignInFailures(key)
+}
+
+func (m *metricsModel) RecordSignInFailure(key string, now, resetBefore time.Time) error {
+	defer m.observe("RecordSignInFailure", time.Now())
+	return m.model.RecordSignInFailure(key, now, resetBefore)
+}
+
+func (m *metricsModel) ClearSignInFailures(key string) error {
+	defer m.observe("ClearSignInFailures", time.Now())
+	return m.model.ClearSignInFailures(key)
+}
+
+func (m *metricsModel) CreatePendingSignIn(now time.Time) (string, error) {
+	defer m.observe("CreatePendingSignIn", time.Now())
+	return m.model.CreatePendingSignIn(now)
+}
+
+func (m *metricsModel) IsPendingSignInValid(id string, createdAfter time.Time) (bool, error) {
+	defer m.observe("IsPendingSignInValid", time.Now())
+	return m.model.IsPendingSignInValid(id, createdAfter)
+}
+
+func (m *metricsModel) DeletePendingSignIn(id string) error {
+	defer m.observe("DeletePendingSignIn", time.Now())
+	return m.model.DeletePendingSignIn(id)
+}
+
+func (m *metricsModel) UseSecondFactor(value string, now time.Time) (bool, error) {
+	defer m.observe("UseSecondFactor", time.Now())
+	return m.model.UseSecondFactor(value, now)
+}
+
+func (m *metricsModel) EnsureSecret(name string, value []byte) ([]byte, error) {
+	defer m.observe("EnsureSecret", time.Now())
+	return m.model.EnsureSecret(name, value)
+}
+
+func (m *metricsModel) ProvisionUser(username string, now time.Time) (bool, error) {
+	defer m.observe("ProvisionUser", time.Now())
+	return m.model.ProvisionUser(username, now)
+}
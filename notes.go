@@ -0,0 +1,68 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// maxNotesLength is the maximum length in bytes of an item's notes.
+const maxNotesLength = 10000
+
+func (s *Server) setNotes(w http.ResponseWriter, r *http.Request) {
+	listID := r.FormValue("list-id")
+	itemID := r.FormValue("item-id")
+	notes := strings.ReplaceAll(r.FormValue("notes"), "\r\n", "\n")
+	notes = strings.TrimSpace(notes)
+	if len(notes) > maxNotesLength {
+		s.errorPage(w, r, http.StatusBadRequest, "Notes too long",
+			"The notes weren't saved because they're longer than "+
+				strconv.Itoa(maxNotesLength)+" characters.")
+		return
+	}
+	err := s.model.SetItemNotes(listID, itemID, notes)
+	if err != nil {
+		s.internalError(w, r, "setting notes", err)
+		return
+	}
+	s.redirect(w, r, "/lists/"+listID)
+}
+
+var urlRegex = regexp.MustCompile(`\bhttps?://[^\s<>"']+`)
+
+// linkify returns the given text as HTML with http and https URLs turned into
+// links. Everything else is escaped, so it's safe to output as-is.
+func linkify(text string) template.HTML {
+	var b strings.Builder
+	last := 0
+	for _, match := range urlRegex.FindAllStringIndex(text, -1) {
+		start, end := match[0], match[1]
+		end = start + len(trimURLPunctuation(text[start:end]))
+		b.WriteString(template.HTMLEscapeString(text[last:start]))
+		link := template.HTMLEscapeString(text[start:end])
+		b.WriteString(`<a href="` + link + `" rel="nofollow noopener noreferrer">` + link + `</a>`)
+		last = end
+	}
+	b.WriteString(template.HTMLEscapeString(text[last:]))
+	return template.HTML(b.String())
+}
+
+// trimURLPunctuation removes trailing punctuation that's probably not part of
+// a URL, like the period in "see https://example.com." (closing parentheses
+// are only removed if they're not matched in the URL).
+func trimURLPunctuation(u string) string {
+	for len(u) > 0 {
+		last := u[len(u)-1]
+		switch {
+		case strings.IndexByte(".,;:!?", last) >= 0:
+			u = u[:len(u)-1]
+		case last == ')' && strings.Count(u, "(") < strings.Count(u, ")"):
+			u = u[:len(u)-1]
+		default:
+			return u
+		}
+	}
+	return u
+}
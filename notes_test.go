@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestLinkify(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"", ""},
+		{"no links\nhere", "no links\nhere"},
+		{"<b>bold</b> & co", "&lt;b&gt;bold&lt;/b&gt; &amp; co"},
+		{"see https://example.com/a?b=1&c=2.", `see <a href="https://example.com/a?b=1&amp;c=2" rel="nofollow noopener noreferrer">https://example.com/a?b=1&amp;c=2</a>.`},
+		{"(http://example.com/x)", `(<a href="http://example.com/x" rel="nofollow noopener noreferrer">http://example.com/x</a>)`},
+		{"http://en.wikipedia.org/wiki/Go_(game)!", `<a href="http://en.wikipedia.org/wiki/Go_(game)" rel="nofollow noopener noreferrer">http://en.wikipedia.org/wiki/Go_(game)</a>!`},
+		{`https://example.com/"onmouseover="alert(1)`, `<a href="https://example.com/" rel="nofollow noopener noreferrer">https://example.com/</a>&#34;onmouseover=&#34;alert(1)`},
+		{"javascript:alert(1) ftp://example.com", "javascript:alert(1) ftp://example.com"},
+		{"a http://x.com b https://y.com", `a <a href="http://x.com" rel="nofollow noopener noreferrer">http://x.com</a> b <a href="https://y.com" rel="nofollow noopener noreferrer">https://y.com</a>`},
+	}
+	for _, test := range tests {
+		t.Run(test.text, func(t *testing.T) {
+			ensureString(t, string(linkify(test.text)), test.want)
+		})
+	}
+}
+
+func TestNotes(t *testing.T) {
+	server, model := newTestServer(t, nullLogger{}, Config{})
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("creating cookie jar: %v", err)
+	}
+	listID, err := model.CreateList("Trip")
+	if err != nil {
+		t.Fatalf("creating list: %v", err)
+	}
+	err = model.AddItems(listID, []*Item{{Description: "Book hotel"}})
+	if err != nil {
+		t.Fatalf("adding items: %v", err)
+	}
+	itemID := "1"
+
+	// Edit form is shown at the top of the list
+	recorder := serve(t, server, jar, "GET", "/lists/"+listID+"?notes="+itemID, nil)
+	ensureCode(t, recorder, http.StatusOK)
+	forms := parseForms(t, recorder.Body.String())
+	ensureString(t, forms[0].Action, "/set-notes")
+	ensureString(t, forms[0].Inputs["item-id"], itemID)
+	ensureString(t, forms[0].Label, "Notes for “Book hotel”")
+
+	form := url.Values{}
+	form.Set("csrf-token", forms[0].Inputs["csrf-token"])
+	form.Set("list-id", listID)
+	form.Set("item-id", itemID)
+	form.Set("notes", "  Near the station\r\nhttps://example.com/hotel?id=1&x=<y>\r\n")
+	recorder = serve(t, server, jar, "POST", "/set-notes", form)
+	ensureRedirect(t, recorder, http.StatusFound, "/lists/"+listID)
+	list, err := model.GetList(listID)
+	if err != nil {
+		t.Fatalf("fetching list: %v", err)
+	}
+	ensureString(t, list.Items[0].Notes, "Near the station\nhttps://example.com/hotel?id=1&x=<y>")
+
+	recorder = serve(t, server, jar, "GET", "/lists/"+listID, nil)
+	body := recorder.Body.String()
+	want := `<div class="notes">Near the station
+<a href="https://example.com/hotel?id=1&amp;x=" rel="nofollow noopener noreferrer">https://example.com/hotel?id=1&amp;x=</a>&lt;y&gt;</div>`
+	if !strings.Contains(body, want) || !strings.Contains(body, `<summary title="Notes">`) {
+		t.Fatalf("notes not shown:\n%s", body)
+	}
+
+	// Notes are shown in the edit form as text
+	recorder = serve(t, server, jar, "GET", "/lists/"+listID+"?notes="+itemID, nil)
+	if !strings.Contains(recorder.Body.String(), "https://example.com/hotel?id=1&amp;x=&lt;y&gt;</textarea>") {
+		t.Fatalf("notes not in form:\n%s", recorder.Body.String())
+	}
+
+	form.Set("notes", strings.Repeat("x", maxNotesLength+1))
+	recorder = serve(t, server, jar, "POST", "/set-notes", form)
+	ensureCode(t, recorder, http.StatusBadRequest)
+}
@@ -0,0 +1,494 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// oidcSignInExpiry is how long the user has to sign in at the issuer.
+	oidcSignInExpiry = 10 * time.Minute
+
+	// oidcClockSkew is how far the issuer's clock may be out from ours when
+	// checking ID token expiry.
+	oidcClockSkew = time.Minute
+
+	oidcTimeout         = 10 * time.Second
+	oidcMaxResponseSize = 1024 * 1024
+)
+
+// oidcProvider is an OpenID Connect client for the configured issuer, using
+// the authorization code flow with PKCE. It's safe for concurrent use.
+type oidcProvider struct {
+	issuer         string
+	clientID       string
+	clientSecret   string
+	redirectURL    string
+	allowedDomains []string // lowercase email domains, or "*" to allow any
+	client         *http.Client
+
+	mu        sync.Mutex
+	discovery *oidcDiscovery            // fetched on first use
+	keys      map[string]*rsa.PublicKey // issuer's signing keys by key ID
+}
+
+// oidcDiscovery is the subset of the issuer's discovery document we use.
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// newOIDCProvider returns a provider for the OIDC settings in config, or nil
+// if OpenID Connect isn't enabled.
+func newOIDCProvider(config Config) *oidcProvider {
+	if config.OIDCIssuer == "" {
+		return nil
+	}
+	var domains []string
+	for _, field := range strings.Split(config.OIDCAllowedDomains, ",") {
+		domain := strings.ToLower(strings.TrimSpace(field))
+		if domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	return &oidcProvider{
+		issuer:         config.OIDCIssuer,
+		clientID:       config.OIDCClientID,
+		clientSecret:   config.OIDCClientSecret,
+		redirectURL:    config.OIDCRedirectURL,
+		allowedDomains: domains,
+		client:         &http.Client{Timeout: oidcTimeout},
+	}
+}
+
+// getDiscovery returns the issuer's discovery document, fetching it the
+// first time it's needed.
+func (p *oidcProvider) getDiscovery(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+	var discovery oidcDiscovery
+	err := p.getJSON(ctx, strings.TrimRight(p.issuer, "/")+"/.well-known/openid-configuration", &discovery)
+	if err != nil {
+		return nil, err
+	}
+	if discovery.Issuer != p.issuer {
+		return nil, fmt.Errorf("discovery issuer %q doesn't match configured issuer %q", discovery.Issuer, p.issuer)
+	}
+	if discovery.AuthorizationEndpoint == "" || discovery.TokenEndpoint == "" || discovery.JWKSURI == "" {
+		return nil, errors.New("discovery document missing endpoints")
+	}
+	p.discovery = &discovery
+	return p.discovery, nil
+}
+
+// getJSON fetches the given URL and decodes the JSON response into v.
+func (p *oidcProvider) getJSON(ctx context.Context, u string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s: status %d", u, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, oidcMaxResponseSize)).Decode(v)
+}
+
+// authURL returns the issuer URL to redirect the user to for signing in.
+func (p *oidcProvider) authURL(discovery *oidcDiscovery, state, nonce, verifier string) string {
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.clientID)
+	params.Set("redirect_uri", p.redirectURL)
+	params.Set("scope", "openid email")
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", pkceChallenge(verifier))
+	params.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return discovery.AuthorizationEndpoint + sep + params.Encode()
+}
+
+// pkceChallenge returns the S256 PKCE code challenge for verifier.
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// exchange exchanges the authorization code for tokens at the issuer's token
+// endpoint, returning the raw ID token.
+func (p *oidcProvider) exchange(ctx context.Context, code, verifier string) (string, error) {
+	discovery, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.redirectURL)
+	form.Set("code_verifier", verifier)
+	if p.clientSecret == "" {
+		form.Set("client_id", p.clientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.clientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.clientID), url.QueryEscape(p.clientSecret))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	err = json.NewDecoder(io.LimitReader(resp.Body, oidcMaxResponseSize)).Decode(&tokens)
+	if err != nil {
+		return "", fmt.Errorf("decoding token response (status %d): %v", resp.StatusCode, err)
+	}
+	if tokens.Error != "" {
+		return "", fmt.Errorf("token endpoint error %q: %s", tokens.Error, tokens.ErrorDescription)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.IDToken == "" {
+		return "", fmt.Errorf("token endpoint returned status %d without ID token", resp.StatusCode)
+	}
+	return tokens.IDToken, nil
+}
+
+// idTokenClaims are the ID token claims we check or use.
+type idTokenClaims struct {
+	Issuer          string       `json:"iss"`
+	Subject         string       `json:"sub"`
+	Audience        oidcAudience `json:"aud"`
+	AuthorizedParty string       `json:"azp"`
+	Expiry          float64      `json:"exp"`
+	Nonce           string       `json:"nonce"`
+	Email           string       `json:"email"`
+	EmailVerified   bool         `json:"email_verified"`
+}
+
+// oidcAudience is an ID token "aud" claim, which may be a single string or
+// an array of strings.
+type oidcAudience []string
+
+func (a *oidcAudience) UnmarshalJSON(data []byte) error {
+	var s string
+	if json.Unmarshal(data, &s) == nil {
+		*a = oidcAudience{s}
+		return nil
+	}
+	var ss []string
+	err := json.Unmarshal(data, &ss)
+	*a = ss
+	return err
+}
+
+func (a oidcAudience) contains(s string) bool {
+	for _, aud := range a {
+		if aud == s {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyIDToken checks the ID token's RS256 signature and its claims, and
+// returns the claims if it's valid.
+func (p *oidcProvider) verifyIDToken(ctx context.Context, rawToken, nonce string, now time.Time) (*idTokenClaims, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed ID token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	err := decodeJWTPart(parts[0], &header)
+	if err != nil {
+		return nil, fmt.Errorf("decoding ID token header: %v", err)
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported ID token algorithm %q", header.Alg)
+	}
+	key, err := p.signingKey(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("decoding ID token signature: %v", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature)
+	if err != nil {
+		return nil, errors.New("invalid ID token signature")
+	}
+
+	var claims idTokenClaims
+	err = decodeJWTPart(parts[1], &claims)
+	if err != nil {
+		return nil, fmt.Errorf("decoding ID token claims: %v", err)
+	}
+	switch {
+	case claims.Issuer != p.issuer:
+		return nil, fmt.Errorf("ID token issuer %q doesn't match", claims.Issuer)
+	case !claims.Audience.contains(p.clientID):
+		return nil, errors.New("ID token not issued for this client")
+	case len(claims.Audience) > 1 && claims.AuthorizedParty != p.clientID:
+		return nil, errors.New("ID token authorized party doesn't match")
+	case now.Add(-oidcClockSkew).Unix() >= int64(claims.Expiry):
+		return nil, errors.New("ID token expired")
+	case subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1:
+		return nil, errors.New("ID token nonce doesn't match")
+	case claims.Subject == "":
+		return nil, errors.New("ID token has no subject")
+	}
+	return &claims, nil
+}
+
+// decodeJWTPart decodes a base64url-encoded JSON part of a JWT into v.
+func decodeJWTPart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// signingKey returns the issuer's RSA signing key with the given key ID,
+// (re)fetching the issuer's keys if it's not known yet, for example after
+// the issuer rotates its keys.
+func (p *oidcProvider) signingKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	discovery, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key := p.findKey(kid); key != nil {
+		return key, nil
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	err = p.getJSON(ctx, discovery.JWKSURI, &jwks)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	p.keys = keys
+	if key := p.findKey(kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("ID token signing key %q not found", kid)
+}
+
+// findKey returns the known signing key with the given ID (or the only key
+// if the ID is empty), or nil if there isn't one. p.mu must be held.
+func (p *oidcProvider) findKey(kid string) *rsa.PublicKey {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key
+		}
+	}
+	return p.keys[kid]
+}
+
+// emailAllowed reports whether the ID token's email is in one of the
+// allowed domains (or any account is allowed with "*"). The issuer must have
+// verified the email address.
+func (p *oidcProvider) emailAllowed(claims *idTokenClaims) bool {
+	for _, allowed := range p.allowedDomains {
+		if allowed == "*" {
+			return true
+		}
+	}
+	at := strings.LastIndex(claims.Email, "@")
+	if !claims.EmailVerified || at < 0 {
+		return false
+	}
+	domain := strings.ToLower(claims.Email[at+1:])
+	for _, allowed := range p.allowedDomains {
+		if domain == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// generateOIDCToken returns a random token for use as an OAuth state, OIDC
+// nonce, or PKCE code verifier.
+func generateOIDCToken() string {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil { // should never fail
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// oidcSignIn starts an OpenID Connect sign-in, redirecting to the issuer.
+// The state, nonce, and PKCE code verifier are kept in a short-lived cookie
+// until the issuer redirects back to oidcCallback.
+func (s *Server) oidcSignIn(w http.ResponseWriter, r *http.Request) {
+	if s.oidc == nil {
+		http.NotFound(w, r)
+		return
+	}
+	discovery, err := s.oidc.getDiscovery(r.Context())
+	if err != nil {
+		s.internalError(w, r, "fetching OpenID Connect configuration", err)
+		return
+	}
+
+	state := url.Values{}
+	state.Set("state", generateOIDCToken())
+	state.Set("nonce", generateOIDCToken())
+	state.Set("verifier", generateOIDCToken())
+	state.Set("return-url", r.FormValue("return-url"))
+	cookie := &http.Cookie{
+		Name:     "oidc-sign-in",
+		Value:    state.Encode(),
+		MaxAge:   int(oidcSignInExpiry / time.Second),
+		Path:     s.cookiePath(),
+		Secure:   r.URL.Scheme == "https",
+		HttpOnly: true,
+		// Must be Lax, as it's needed when the issuer redirects back
+		SameSite: http.SameSiteLaxMode,
+	}
+	http.SetCookie(w, cookie)
+
+	authURL := s.oidc.authURL(discovery, state.Get("state"), state.Get("nonce"), state.Get("verifier"))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// oidcCallback handles the issuer's redirect back after the user signs in,
+// exchanging the code for an ID token and creating a sign-in for its subject.
+func (s *Server) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	if s.oidc == nil {
+		http.NotFound(w, r)
+		return
+	}
+	state, _ := url.ParseQuery(getCookie(r, "oidc-sign-in"))
+	cookie := &http.Cookie{
+		Name:     "oidc-sign-in",
+		MaxAge:   -1,
+		Path:     s.cookiePath(),
+		Secure:   r.URL.Scheme == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	http.SetCookie(w, cookie)
+
+	returnURL := state.Get("return-url")
+	if !strings.HasPrefix(returnURL, "/") || strings.HasPrefix(returnURL, "//") {
+		returnURL = "/"
+	}
+	fail := func(reason string, keyvals ...interface{}) {
+		s.metrics.incFailedSignIns()
+		keyvals = append([]interface{}{"reason", reason, "remote", clientIP(r)}, keyvals...)
+		keyvals = append(keyvals, "request_id", getRequestInfo(r).id)
+		s.logger.Log(LevelWarn, "sign in OIDC failed", keyvals...)
+		s.redirect(w, r, "/?error=oidc&return-url="+url.QueryEscape(returnURL))
+	}
+
+	query := r.URL.Query()
+	if query.Get("error") != "" {
+		fail("issuer returned error", "error", query.Get("error"))
+		return
+	}
+	expected := state.Get("state")
+	if expected == "" || subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(expected)) != 1 {
+		fail("state doesn't match")
+		return
+	}
+	idToken, err := s.oidc.exchange(r.Context(), query.Get("code"), state.Get("verifier"))
+	if err != nil {
+		fail("exchanging code", "error", err)
+		return
+	}
+	claims, err := s.oidc.verifyIDToken(r.Context(), idToken, state.Get("nonce"), s.now())
+	if err != nil {
+		fail("verifying ID token", "error", err)
+		return
+	}
+	if !s.oidc.emailAllowed(claims) {
+		fail("email not allowed", "email", claims.Email)
+		return
+	}
+
+	err = s.createSignIn(w, r, claims.Subject)
+	if err != nil {
+		s.internalError(w, r, "creating sign in", err)
+		return
+	}
+	s.logger.Log(LevelInfo, "signed in with OIDC", "subject", claims.Subject,
+		"email", claims.Email, "request_id", getRequestInfo(r).id)
+
+	// The browser won't send the SameSite=Strict sign-in cookie when
+	// following a redirect that started at the issuer, so redirect from a
+	// page on this site instead.
+	err = s.redirectTmpl.Execute(w, struct{ ReturnURL string }{returnURL})
+	if err != nil {
+		s.internalError(w, r, "rendering template", err)
+		return
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOIDCSignIn(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+
+	// Mock issuer that issues an ID token for the last authorization request
+	var challenge, nonce, email string
+	mux := http.NewServeMux()
+	issuer := httptest.NewServer(mux)
+	defer issuer.Close()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer.URL,
+			"authorization_endpoint": issuer.URL + "/authorize",
+			"token_endpoint":         issuer.URL + "/token",
+			"jwks_uri":               issuer.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": "key1",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		clientID, clientSecret, _ := r.BasicAuth()
+		if r.FormValue("code") != "code123" || clientID != "simplelists" || clientSecret != "s3cret" ||
+			pkceChallenge(r.FormValue("code_verifier")) != challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		idToken := signTestJWT(t, key, "key1", map[string]interface{}{
+			"iss":            issuer.URL,
+			"sub":            "user-42",
+			"aud":            "simplelists",
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"nonce":          nonce,
+			"email":          email,
+			"email_verified": true,
+		})
+		json.NewEncoder(w).Encode(map[string]string{"id_token": idToken})
+	})
+
+	server, _ := newTestServer(t, nullLogger{}, Config{
+		OIDCIssuer:         issuer.URL,
+		OIDCClientID:       "simplelists",
+		OIDCClientSecret:   "s3cret",
+		OIDCRedirectURL:    "http://localhost/oidc-callback",
+		OIDCAllowedDomains: "example.com",
+	})
+
+	// startSignIn starts a sign-in in a new browser session and returns the
+	// session's cookie jar and the state sent to the issuer.
+	startSignIn := func() (http.CookieJar, string) {
+		t.Helper()
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			t.Fatalf("creating cookie jar: %v", err)
+		}
+		recorder := serve(t, server, jar, "GET", "/", nil)
+		forms := parseForms(t, recorder.Body.String())
+		ensureInt(t, len(forms), 1) // no password form
+		ensureString(t, forms[0].Action, "/sign-in-oidc")
+		form := url.Values{}
+		form.Set("csrf-token", forms[0].Inputs["csrf-token"])
+		form.Set("return-url", "/lists/abc")
+		recorder = serve(t, server, jar, "POST", "/sign-in-oidc", form)
+		ensureCode(t, recorder, http.StatusFound)
+		location, err := url.Parse(recorder.Result().Header.Get("Location"))
+		if err != nil {
+			t.Fatalf("parsing location: %v", err)
+		}
+		ensureString(t, location.Scheme+"://"+location.Host+location.Path, issuer.URL+"/authorize")
+		params := location.Query()
+		ensureString(t, params.Get("client_id"), "simplelists")
+		ensureString(t, params.Get("redirect_uri"), "http://localhost/oidc-callback")
+		ensureString(t, params.Get("code_challenge_method"), "S256")
+		challenge = params.Get("code_challenge")
+		nonce = params.Get("nonce")
+		return jar, params.Get("state")
+	}
+	callback := func(jar http.CookieJar, code, state string) *httptest.ResponseRecorder {
+		t.Helper()
+		return serve(t, server, jar, "GET", "/oidc-callback?code="+code+"&state="+url.QueryEscape(state), nil)
+	}
+
+	// Successful sign-in
+	email = "alice@Example.com"
+	jar, state := startSignIn()
+	recorder := callback(jar, "code123", state)
+	ensureCode(t, recorder, http.StatusOK)
+	if !strings.Contains(recorder.Body.String(), `content="0; url=/lists/abc"`) {
+		t.Fatalf("redirect not found in body:\n%s", recorder.Body.String())
+	}
+	recorder = serve(t, server, jar, "GET", "/", nil)
+	forms := parseForms(t, recorder.Body.String())
+	ensureString(t, forms[0].Action, "/sign-out")
+
+	// Failures: wrong state, wrong code, and email domain not allowed
+	jar, state = startSignIn()
+	recorder = callback(jar, "code123", state+"x")
+	ensureRedirect(t, recorder, http.StatusFound, "/?error=oidc&return-url=%2Flists%2Fabc")
+	jar, state = startSignIn()
+	recorder = callback(jar, "wrong", state)
+	ensureRedirect(t, recorder, http.StatusFound, "/?error=oidc&return-url=%2Flists%2Fabc")
+	email = "eve@example.com.evil.com"
+	jar, state = startSignIn()
+	recorder = callback(jar, "code123", state)
+	ensureRedirect(t, recorder, http.StatusFound, "/?error=oidc&return-url=%2Flists%2Fabc")
+	recorder = serve(t, server, jar, "GET", "/lists/abc", nil)
+	ensureRedirect(t, recorder, http.StatusFound, "/?return-url=%2Flists%2Fabc")
+}
+
+func TestOIDCEmailAllowed(t *testing.T) {
+	tests := []struct {
+		domains  string
+		email    string
+		verified bool
+		allowed  bool
+	}{
+		{"example.com", "bob@example.com", true, true},
+		{"example.com, Example.org", "bob@EXAMPLE.ORG", true, true},
+		{"example.com", "bob@example.com", false, false},
+		{"example.com", "eve@evil.com", true, false},
+		{"*", "eve@evil.com", true, true},
+		{"", "bob@example.com", true, false},
+	}
+	for _, test := range tests {
+		provider := newOIDCProvider(Config{OIDCIssuer: "https://issuer.example.com", OIDCAllowedDomains: test.domains})
+		claims := &idTokenClaims{Email: test.email, EmailVerified: test.verified}
+		allowed := provider.emailAllowed(claims)
+		if allowed != test.allowed {
+			t.Errorf("domains %q, email %q, verified %v: got %v, want %v",
+				test.domains, test.email, test.verified, allowed, test.allowed)
+		}
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	provider := &oidcProvider{
+		issuer:    "https://issuer.example.com",
+		clientID:  "simplelists",
+		discovery: &oidcDiscovery{},
+		keys:      map[string]*rsa.PublicKey{"key1": &key.PublicKey},
+	}
+	now := time.Date(2021, 9, 10, 12, 0, 0, 0, time.UTC)
+	claims := func(changes ...interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"iss":   "https://issuer.example.com",
+			"sub":   "user-42",
+			"aud":   []string{"simplelists"},
+			"exp":   now.Add(time.Minute).Unix(),
+			"nonce": "n0nce",
+		}
+		for i := 0; i < len(changes); i += 2 {
+			c[changes[i].(string)] = changes[i+1]
+		}
+		return c
+	}
+
+	noneJWT := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	tests := []struct {
+		token string
+		err   string
+	}{
+		{signTestJWT(t, key, "key1", claims()), ""},
+		{signTestJWT(t, key, "key1", claims("iss", "https://evil.com")), `ID token issuer "https://evil.com" doesn't match`},
+		{signTestJWT(t, key, "key1", claims("aud", "other")), "ID token not issued for this client"},
+		{signTestJWT(t, key, "key1", claims("aud", []string{"simplelists", "other"})), "ID token authorized party doesn't match"},
+		{signTestJWT(t, key, "key1", claims("exp", now.Add(-2*time.Minute).Unix())), "ID token expired"},
+		{signTestJWT(t, key, "key1", claims("nonce", "other")), "ID token nonce doesn't match"},
+		{signTestJWT(t, key, "key1", claims())[:20] + "x", "malformed ID token"},
+		{noneJWT + "." + strings.Split(signTestJWT(t, key, "key1", claims()), ".")[1] + ".", `unsupported ID token algorithm "none"`},
+	}
+	for _, test := range tests {
+		_, err := provider.verifyIDToken(context.Background(), test.token, "n0nce", now)
+		ensureString(t, errString(err), test.err)
+	}
+
+	// Tampered claims don't verify
+	parts := strings.Split(signTestJWT(t, key, "key1", claims()), ".")
+	tampered, _ := json.Marshal(claims("sub", "admin"))
+	parts[1] = base64.RawURLEncoding.EncodeToString(tampered)
+	_, err = provider.verifyIDToken(context.Background(), strings.Join(parts, "."), "n0nce", now)
+	ensureString(t, errString(err), "invalid ID token signature")
+}
+
+// signTestJWT returns an RS256-signed JWT with the given claims.
+func signTestJWT(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	if err != nil {
+		t.Fatalf("encoding header: %v", err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("encoding claims: %v", err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("signing token: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
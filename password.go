@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Argon2Params are the tunable argon2id parameters.
+type Argon2Params struct {
+	Memory      uint32 // memory in KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32 // salt length in bytes
+	KeyLength   uint32 // hash length in bytes
+}
+
+// DefaultArgon2Params are the default argon2id parameters, the second
+// recommended option from RFC 9106 (the first needs 2 GiB of memory).
+var DefaultArgon2Params = Argon2Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 4,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// argon2Prefix is the start of an argon2id hash in PHC string format.
+const argon2Prefix = "$argon2id$"
+
+// GeneratePasswordHash generates an argon2id hash from the given password
+// using the default parameters.
+func GeneratePasswordHash(password string) (string, error) {
+	return GenerateArgon2Hash(password, DefaultArgon2Params)
+}
+
+// GenerateArgon2Hash generates an argon2id hash from the given password in
+// PHC string format, for example "$argon2id$v=19$m=65536,t=3,p=4$salt$hash".
+func GenerateArgon2Hash(password string, params Argon2Params) (string, error) {
+	if params.Memory < 8*uint32(params.Parallelism) || params.Iterations < 1 || params.Parallelism < 1 {
+		return "", errors.New("invalid argon2 parameters (need at least 1 iteration and 1 thread, and 8 KiB memory per thread)")
+	}
+	if params.SaltLength < 8 || params.KeyLength < 16 {
+		return "", errors.New("argon2 salt or key length too short")
+	}
+	salt := make([]byte, params.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2Prefix, argon2.Version,
+		params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// parseArgon2Hash parses an argon2id hash in PHC string format.
+func parseArgon2Hash(hash string) (params Argon2Params, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return params, nil, nil, errors.New("invalid argon2id hash format")
+	}
+	var version int
+	_, err = fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return params, nil, nil, fmt.Errorf("unsupported argon2id version %q", parts[2])
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil || params.Iterations < 1 || params.Parallelism < 1 {
+		return params, nil, nil, fmt.Errorf("invalid argon2id parameters %q", parts[3])
+	}
+	salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, errors.New("invalid argon2id salt")
+	}
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, errors.New("invalid argon2id hash")
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
+
+// maxPasswordChecks is the maximum number of password hashes computed at
+// once. Each argon2id check allocates the hash's memory (64 MiB by default),
+// so without a limit many parallel sign-in attempts could run the server out
+// of memory before the lockout kicks in.
+const maxPasswordChecks = 2
+
+// passwordChecks is a semaphore limiting concurrent password checks.
+var passwordChecks = make(chan struct{}, maxPasswordChecks)
+
+// checkPassword reports whether password matches the given argon2id or
+// bcrypt password hash. If maxPasswordChecks are already in progress, it
+// waits for one to finish.
+func checkPassword(passwordHash, password string) bool {
+	passwordChecks <- struct{}{}
+	defer func() { <-passwordChecks }()
+
+	if !strings.HasPrefix(passwordHash, argon2Prefix) {
+		return bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)) == nil
+	}
+	params, salt, key, err := parseArgon2Hash(passwordHash)
+	if err != nil {
+		return false
+	}
+	other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	return subtle.ConstantTimeCompare(key, other) == 1
+}
+
+// CheckPasswordHash returns a non-nil error if the given password hash is not
+// a valid argon2id or bcrypt hash.
+func CheckPasswordHash(passwordHash string) error {
+	if strings.HasPrefix(passwordHash, argon2Prefix) {
+		_, _, _, err := parseArgon2Hash(passwordHash)
+		return err
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte("x"))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return nil
+	}
+	return err
+}
+
+// isWeakPasswordHash reports whether the password hash uses weaker
+// parameters than the current defaults (a bcrypt hash with a lower cost
+// than bcrypt's default, or an argon2id hash with lower parameters).
+func isWeakPasswordHash(passwordHash string) bool {
+	if !strings.HasPrefix(passwordHash, argon2Prefix) {
+		cost, err := bcrypt.Cost([]byte(passwordHash))
+		return err == nil && cost < bcrypt.DefaultCost
+	}
+	params, _, _, err := parseArgon2Hash(passwordHash)
+	if err != nil {
+		return false
+	}
+	return params.weakerThan(DefaultArgon2Params)
+}
+
+// weakerThan reports whether any of p's cost or length parameters are lower
+// than q's. Parallelism isn't included, as it doesn't affect the cost.
+func (p Argon2Params) weakerThan(q Argon2Params) bool {
+	return p.Memory < q.Memory || p.Iterations < q.Iterations ||
+		p.SaltLength < q.SaltLength || p.KeyLength < q.KeyLength
+}
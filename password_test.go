@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordHashes(t *testing.T) {
+	weakParams := Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	argon2Hash, err := GenerateArgon2Hash("password", weakParams)
+	if err != nil {
+		t.Fatalf("generating argon2 hash: %v", err)
+	}
+	ensureRegex(t, argon2Hash, `\$argon2id\$v=19\$m=1024,t=1,p=1\$[A-Za-z0-9+/]{22}\$[A-Za-z0-9+/]{43}`)
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("generating bcrypt hash: %v", err)
+	}
+	defaultHash, err := GeneratePasswordHash("password")
+	if err != nil {
+		t.Fatalf("generating default hash: %v", err)
+	}
+
+	tests := []struct {
+		hash string
+		weak bool
+	}{
+		{argon2Hash, true},
+		{string(bcryptHash), true},
+		{defaultHash, false},
+	}
+	for _, test := range tests {
+		err := CheckPasswordHash(test.hash)
+		if err != nil {
+			t.Errorf("CheckPasswordHash(%q): %v", test.hash, err)
+		}
+		if !checkPassword(test.hash, "password") {
+			t.Errorf("correct password not accepted for %q", test.hash)
+		}
+		if checkPassword(test.hash, "Password") {
+			t.Errorf("incorrect password accepted for %q", test.hash)
+		}
+		if isWeakPasswordHash(test.hash) != test.weak {
+			t.Errorf("isWeakPasswordHash(%q): got %v, want %v", test.hash, !test.weak, test.weak)
+		}
+	}
+
+	for _, hash := range []string{
+		"",
+		"$2a$10$short",
+		"$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHQ",
+		"$argon2id$v=16$m=1024,t=1,p=1$c2FsdHNhbHQ$aGFzaGhhc2g",
+		"$argon2id$v=19$m=1024,t=0,p=1$c2FsdHNhbHQ$aGFzaGhhc2g",
+		"$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHQ$!!!",
+	} {
+		if CheckPasswordHash(hash) == nil {
+			t.Errorf("CheckPasswordHash(%q): expected error", hash)
+		}
+		if checkPassword(hash, "password") {
+			t.Errorf("password accepted for invalid hash %q", hash)
+		}
+	}
+
+	_, err = GenerateArgon2Hash("password", Argon2Params{Memory: 1024, Iterations: 0, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+	if err == nil || !strings.Contains(err.Error(), "invalid argon2 parameters") {
+		t.Errorf("expected invalid parameters error, got %v", err)
+	}
+}
+
+func TestPasswordChecksLimited(t *testing.T) {
+	hash, err := GenerateArgon2Hash("password", Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+	if err != nil {
+		t.Fatalf("generating argon2 hash: %v", err)
+	}
+
+	// With the maximum number of checks in progress, another check waits
+	for i := 0; i < maxPasswordChecks; i++ {
+		passwordChecks <- struct{}{}
+	}
+	done := make(chan bool)
+	go func() {
+		done <- checkPassword(hash, "password")
+	}()
+	select {
+	case <-done:
+		t.Fatalf("password check didn't wait")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Once one finishes, it goes ahead
+	<-passwordChecks
+	if !<-done {
+		t.Fatalf("correct password not accepted")
+	}
+	for i := 1; i < maxPasswordChecks; i++ {
+		<-passwordChecks
+	}
+}
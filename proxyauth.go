@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseTrustedProxies parses a comma-separated list of IP addresses and
+// CIDR ranges, for example "127.0.0.1,10.0.0.0/8". The special value "unix"
+// means a proxy connecting over the Unix socket is trusted.
+func ParseTrustedProxies(s string) (nets []*net.IPNet, unix bool, err error) {
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if field == "unix" {
+			unix = true
+			continue
+		}
+		if strings.Contains(field, "/") {
+			_, ipNet, err := net.ParseCIDR(field)
+			if err != nil {
+				return nil, false, fmt.Errorf("invalid CIDR %q", field)
+			}
+			nets = append(nets, ipNet)
+			continue
+		}
+		ip := net.ParseIP(field)
+		if ip == nil {
+			return nil, false, fmt.Errorf("invalid IP address %q", field)
+		}
+		bits := 8 * net.IPv4len
+		if ip.To4() == nil {
+			bits = 8 * net.IPv6len
+		}
+		nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return nets, unix, nil
+}
+
+// isTrustedProxy reports whether the request came directly from one of the
+// trusted proxies.
+func (s *Server) isTrustedProxy(r *http.Request) bool {
+	if isUnixSocket(r) {
+		// Client IP is meaningless, only the socket's permissions matter
+		return s.trustUnixSocket
+	}
+	ip := net.ParseIP(clientIP(r))
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range s.trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isUnixSocket reports whether the request came in over a Unix socket.
+func isUnixSocket(r *http.Request) bool {
+	addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	return ok && addr.Network() == "unix"
+}
+
+// proxyUser returns the user set in the auth header by a trusted proxy, or
+// "" if the request didn't come from a trusted proxy or the header is empty.
+// The header is ignored from anyone else, as it's trivial to forge.
+func (s *Server) proxyUser(r *http.Request) string {
+	if !s.isTrustedProxy(r) {
+		return ""
+	}
+	return strings.TrimSpace(r.Header.Get(s.authHeader))
+}
+
+// isProxySignedIn reports whether a trusted proxy has authenticated the
+// request, provisioning the user the first time they're seen.
+func (s *Server) isProxySignedIn(r *http.Request) bool {
+	username := s.proxyUser(r)
+	if username == "" {
+		return false
+	}
+	getRequestInfo(r).user = username
+	if _, ok := s.provisioned.Load(username); ok {
+		return true
+	}
+	created, err := s.model.ProvisionUser(username, s.now())
+	if err != nil {
+		s.logger.Log(LevelError, "error provisioning user", "error", err,
+			"username", username, "request_id", getRequestInfo(r).id)
+		return false
+	}
+	if created {
+		s.logger.Log(LevelInfo, "provisioned user", "username", username,
+			"request_id", getRequestInfo(r).id)
+	}
+	s.provisioned.Store(username, true)
+	return true
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestProxyAuth(t *testing.T) {
+	server, model := newTestServer(t, nullLogger{}, Config{
+		Lists:          true,
+		AuthHeader:     "X-Forwarded-User",
+		TrustedProxies: "10.0.0.1, 192.168.0.0/16",
+	})
+
+	get := func(path, remoteAddr, user string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest("GET", "http://localhost"+path, nil)
+		r.RemoteAddr = remoteAddr
+		if user != "" {
+			r.Header.Set("X-Forwarded-User", user)
+		}
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, r)
+		return recorder
+	}
+
+	// Header is trusted from configured proxies, and sign-in and sign-out
+	// forms are hidden
+	recorder := get("/", "10.0.0.1:1234", "alice")
+	ensureCode(t, recorder, http.StatusOK)
+	forms := parseForms(t, recorder.Body.String())
+	ensureInt(t, len(forms), 1)
+	ensureString(t, forms[0].Action, "/create-list")
+	recorder = get("/sessions", "192.168.1.2:1234", "alice")
+	ensureCode(t, recorder, http.StatusNotFound)
+
+	// User is provisioned on first request only
+	created, err := model.ProvisionUser("alice", server.now())
+	if err != nil {
+		t.Fatalf("provisioning user: %v", err)
+	}
+	if created {
+		t.Fatalf("user not provisioned by proxy request")
+	}
+
+	// CSRF token is tied to the proxy's user
+	aliceToken := parseForms(t, get("/", "10.0.0.1:1234", "alice").Body.String())[0].Inputs["csrf-token"]
+	bobToken := parseForms(t, get("/", "10.0.0.1:1234", "bob").Body.String())[0].Inputs["csrf-token"]
+	if aliceToken == bobToken {
+		t.Fatalf("CSRF token not tied to proxy user")
+	}
+	createList := func(user, token string) *httptest.ResponseRecorder {
+		t.Helper()
+		form := url.Values{}
+		form.Set("csrf-token", token)
+		form.Set("name", "Shopping")
+		r := httptest.NewRequest("POST", "http://localhost/create-list", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.RemoteAddr = "10.0.0.1:1234"
+		r.Header.Set("X-Forwarded-User", user)
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, r)
+		return recorder
+	}
+	ensureCode(t, createList("alice", bobToken), http.StatusBadRequest)
+	ensureCode(t, createList("alice", aliceToken), http.StatusFound)
+
+	// Header is ignored from anywhere else, and there's no sign-in form
+	recorder = get("/", "10.0.0.2:1234", "alice")
+	ensureCode(t, recorder, http.StatusUnauthorized)
+	recorder = get("/lists/abc", "10.0.0.2:1234", "alice")
+	ensureCode(t, recorder, http.StatusUnauthorized)
+	recorder = get("/", "10.0.0.1:1234", "")
+	ensureCode(t, recorder, http.StatusUnauthorized)
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	nets, unix, err := ParseTrustedProxies("127.0.0.1, ::1,10.0.0.0/8,unix")
+	if err != nil {
+		t.Fatalf("parsing trusted proxies: %v", err)
+	}
+	if !unix {
+		t.Fatalf("expected Unix socket to be trusted")
+	}
+	ensureInt(t, len(nets), 3)
+	ensureString(t, nets[0].String(), "127.0.0.1/32")
+	ensureString(t, nets[1].String(), "::1/128")
+	ensureString(t, nets[2].String(), "10.0.0.0/8")
+
+	_, _, err = ParseTrustedProxies("10.0.0.1,proxy.local")
+	ensureString(t, errString(err), `invalid IP address "proxy.local"`)
+}
+
+func TestProxyAuthUnixSocket(t *testing.T) {
+	get := func(server *Server, user string) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest("GET", "http://localhost/", nil)
+		r.RemoteAddr = "@"
+		addr := &net.UnixAddr{Name: "/run/simplelists.sock", Net: "unix"}
+		r = r.WithContext(context.WithValue(r.Context(), http.LocalAddrContextKey, addr))
+		r.Header.Set("X-Forwarded-User", user)
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, r)
+		return recorder
+	}
+
+	trusted, _ := newTestServer(t, nullLogger{}, Config{
+		AuthHeader:     "X-Forwarded-User",
+		TrustedProxies: "unix",
+	})
+	ensureCode(t, get(trusted, "alice"), http.StatusOK)
+	ensureCode(t, get(trusted, ""), http.StatusUnauthorized)
+
+	// Unix socket isn't trusted unless configured
+	untrusted, _ := newTestServer(t, nullLogger{}, Config{
+		AuthHeader:     "X-Forwarded-User",
+		TrustedProxies: "127.0.0.1",
+	})
+	ensureCode(t, get(untrusted, "alice"), http.StatusUnauthorized)
+}
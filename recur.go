@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Repeat rules are stored as short strings: "" (doesn't repeat), "daily",
+// "monthly:" followed by the day of month, for example "monthly:31", or
+// "weekly:" followed by comma-separated weekday abbreviations, for example
+// "weekly:mon,thu". Older rules may be just "monthly", which repeats on the
+// day of month the item is due.
+
+var weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// parseRepeat returns the repeat rule for the given frequency ("", "daily",
+// "weekly", or "monthly") and, for weekly items, weekdays (like "mon"). If
+// no weekdays are given for a weekly item, it repeats on the weekday of
+// date. Monthly items repeat on the day of month of date.
+func parseRepeat(frequency string, weekdays []string, date time.Time) (string, error) {
+	switch frequency {
+	case "", "daily":
+		return frequency, nil
+	case "monthly":
+		return "monthly:" + strconv.Itoa(date.Day()), nil
+	case "weekly":
+		var days [7]bool
+		for _, name := range weekdays {
+			day := weekdayIndex(name)
+			if day < 0 {
+				return "", fmt.Errorf("invalid weekday %q", name)
+			}
+			days[day] = true
+		}
+		if len(weekdays) == 0 {
+			days[date.Weekday()] = true
+		}
+		var names []string
+		for i := 1; i <= 7; i++ { // week starts on Monday
+			if day := i % 7; days[day] {
+				names = append(names, weekdayNames[day])
+			}
+		}
+		return "weekly:" + strings.Join(names, ","), nil
+	default:
+		return "", fmt.Errorf("invalid repeat frequency %q", frequency)
+	}
+}
+
+func weekdayIndex(name string) int {
+	for i, n := range weekdayNames {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// repeatMonthDay returns the day of month a "monthly:..." rule repeats on,
+// or 0 if the rule doesn't include a valid day.
+func repeatMonthDay(rule string) int {
+	day, err := strconv.Atoi(strings.TrimPrefix(rule, "monthly:"))
+	if err != nil || day < 1 || day > 31 {
+		return 0
+	}
+	return day
+}
+
+// repeatWeekdays returns the weekdays a "weekly:..." rule repeats on.
+func repeatWeekdays(rule string) [7]bool {
+	var days [7]bool
+	for _, name := range strings.Split(strings.TrimPrefix(rule, "weekly:"), ",") {
+		if day := weekdayIndex(name); day >= 0 {
+			days[day] = true
+		}
+	}
+	return days
+}
+
+// describeRepeat returns a human-readable description of a repeat rule, for
+// example "every Mon, Thu".
+func describeRepeat(rule string) string {
+	switch {
+	case rule == "daily":
+		return "every day"
+	case rule == "monthly":
+		return "every month"
+	case strings.HasPrefix(rule, "monthly:"):
+		day := repeatMonthDay(rule)
+		if day == 0 {
+			return ""
+		}
+		return "every month on the " + ordinal(day)
+	case strings.HasPrefix(rule, "weekly:"):
+		var names []string
+		for _, name := range strings.Split(strings.TrimPrefix(rule, "weekly:"), ",") {
+			if weekdayIndex(name) >= 0 {
+				names = append(names, strings.ToUpper(name[:1])+name[1:])
+			}
+		}
+		return "every " + strings.Join(names, ", ")
+	default:
+		return ""
+	}
+}
+
+// ordinal returns n with its English ordinal suffix, for example "31st".
+func ordinal(n int) string {
+	suffix := "th"
+	switch {
+	case n%100 >= 11 && n%100 <= 13:
+	case n%10 == 1:
+		suffix = "st"
+	case n%10 == 2:
+		suffix = "nd"
+	case n%10 == 3:
+		suffix = "rd"
+	}
+	return strconv.Itoa(n) + suffix
+}
+
+// nextOccurrence returns when the next copy of a recurring item is due. The
+// next occurrence is the first date matching the rule that's after both
+// the item's due date and today, at the same time of day as the due date.
+// If the item has no due date, the next occurrence is relative to today. The
+// calculation is done in now's timezone, so "daily" means the same local
+// time every day, even across daylight saving changes. It returns the zero
+// time if rule is not a valid repeat rule.
+func nextOccurrence(rule string, due, now time.Time) time.Time {
+	location := now.Location()
+	anchor := due.In(location)
+	if due.IsZero() {
+		// No due date, so the copy is due all day (from the start of the day)
+		year, month, day := now.Date()
+		anchor = time.Date(year, month, day, 0, 0, 0, 0, location)
+	}
+	hour, minute, _ := anchor.Clock()
+	after := anchor
+	if after.Before(now) {
+		after = now
+	}
+	year, month, day := after.Date()
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, location)
+	}
+
+	switch {
+	case rule == "daily":
+		return at(year, month, day+1)
+	case rule == "monthly" || strings.HasPrefix(rule, "monthly:"):
+		// Same day of month as the rule, or the last day of shorter months.
+		// The rule's day is used rather than the due date's so that a copy
+		// due on the 28th of February is followed by one on the 31st of March.
+		ruleDay := anchor.Day() // older rules without a day
+		if rule != "monthly" {
+			ruleDay = repeatMonthDay(rule)
+			if ruleDay == 0 {
+				return time.Time{}
+			}
+		}
+		for i := 0; ; i++ {
+			next := at(year, month+time.Month(i), 1)
+			monthDay := next.AddDate(0, 1, -1).Day() // days in month
+			if ruleDay < monthDay {
+				monthDay = ruleDay
+			}
+			next = at(next.Year(), next.Month(), monthDay)
+			if i > 0 || next.Day() > day {
+				return next
+			}
+		}
+	case strings.HasPrefix(rule, "weekly:"):
+		days := repeatWeekdays(rule)
+		for i := 1; i <= 7; i++ {
+			next := at(year, month, day+i)
+			if days[next.Weekday()] {
+				return next
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// weekdayOption is a weekday checkbox in the list page's repeat form.
+type weekdayOption struct {
+	Value   string // like "mon"
+	Checked bool
+}
+
+// weekdayOptions returns the weekday checkboxes for editing an item's repeat
+// rule, starting on Monday. The item's weekdays are checked if it repeats
+// weekly, otherwise the weekday it's due (or today).
+func (s *Server) weekdayOptions(item *Item) []weekdayOption {
+	var days [7]bool
+	if strings.HasPrefix(item.Repeat, "weekly:") {
+		days = repeatWeekdays(item.Repeat)
+	} else {
+		days[s.itemDate(item).Weekday()] = true
+	}
+	var options []weekdayOption
+	for i := 1; i <= 7; i++ {
+		day := i % 7
+		options = append(options, weekdayOption{weekdayNames[day], days[day]})
+	}
+	return options
+}
+
+// itemDate returns the date an item is due, or today if it has no due date,
+// in the display timezone.
+func (s *Server) itemDate(item *Item) time.Time {
+	if item.TimeDue.IsZero() {
+		return s.now().In(s.location)
+	}
+	return item.TimeDue.In(s.location)
+}
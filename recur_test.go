@@ -0,0 +1,246 @@
+package main
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNextOccurrence(t *testing.T) {
+	location, err := time.LoadLocation("Pacific/Auckland")
+	if err != nil {
+		t.Fatalf("loading location: %v", err)
+	}
+	date := func(s string) time.Time {
+		t.Helper()
+		if s == "" {
+			return time.Time{}
+		}
+		d, err := time.ParseInLocation("2006-01-02 15:04", s, location)
+		if err != nil {
+			t.Fatalf("parsing date: %v", err)
+		}
+		return d
+	}
+	now := date("2021-09-10 12:00") // a Friday
+
+	tests := []struct {
+		rule string
+		due  string
+		want string
+	}{
+		// Next occurrence is after today and the due date
+		{"daily", "", "2021-09-11 00:00"},
+		{"daily", "2021-09-10 09:00", "2021-09-11 09:00"},
+		{"daily", "2021-09-01 09:00", "2021-09-11 09:00"},
+		{"daily", "2021-09-20 09:00", "2021-09-21 09:00"},
+		// Same local time across the daylight saving change on 26 September
+		{"daily", "2021-09-25 09:00", "2021-09-26 09:00"},
+		{"weekly:mon,thu", "2021-09-09 00:00", "2021-09-13 00:00"},
+		{"weekly:fri", "2021-09-10 18:00", "2021-09-17 18:00"},
+		{"weekly:sat", "", "2021-09-11 00:00"},
+		{"monthly:10", "2021-09-10 00:00", "2021-10-10 00:00"},
+		{"monthly:15", "2021-08-15 00:00", "2021-09-15 00:00"},
+		{"monthly:31", "2021-08-31 00:00", "2021-09-30 00:00"},
+		{"monthly:31", "2021-09-30 00:00", "2021-10-31 00:00"},
+		{"monthly:30", "2021-09-30 00:00", "2021-10-30 00:00"},
+		{"monthly:31", "2022-01-31 00:00", "2022-02-28 00:00"},
+		{"monthly:31", "2022-02-28 00:00", "2022-03-31 00:00"},
+		{"monthly:20", "", "2021-09-20 00:00"},
+		{"monthly", "2021-08-15 00:00", "2021-09-15 00:00"}, // older rule without a day
+		{"monthly:32", "2021-09-10 00:00", ""},
+		{"", "2021-09-10 00:00", ""},
+		{"yearly", "2021-09-10 00:00", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.rule+" "+test.due, func(t *testing.T) {
+			next := nextOccurrence(test.rule, date(test.due), now)
+			if !next.Equal(date(test.want)) {
+				t.Fatalf("got %s, want %s", next, test.want)
+			}
+		})
+	}
+
+	// Each copy is due on the rule's day, so month-end items don't drift
+	due := date("2022-01-31 09:00")
+	var dates []string
+	for i := 0; i < 3; i++ {
+		due = nextOccurrence("monthly:31", due, due)
+		dates = append(dates, due.Format("2006-01-02"))
+	}
+	ensureString(t, strings.Join(dates, ", "), "2022-02-28, 2022-03-31, 2022-04-30")
+}
+
+func TestParseRepeat(t *testing.T) {
+	tests := []struct {
+		frequency string
+		weekdays  []string
+		want      string
+		wantDesc  string
+		wantErr   string
+	}{
+		{"", nil, "", "", ""},
+		{"daily", []string{"mon"}, "daily", "every day", ""},
+		{"monthly", nil, "monthly:15", "every month on the 15th", ""},
+		{"weekly", []string{"sun", "thu", "mon"}, "weekly:mon,thu,sun", "every Mon, Thu, Sun", ""},
+		{"weekly", nil, "weekly:wed", "every Wed", ""},
+		{"weekly", []string{"funday"}, "", "", `invalid weekday "funday"`},
+		{"yearly", nil, "", "", `invalid repeat frequency "yearly"`},
+	}
+	for _, test := range tests {
+		t.Run(test.frequency, func(t *testing.T) {
+			date := time.Date(2021, 9, 15, 0, 0, 0, 0, time.UTC) // a Wednesday
+			rule, err := parseRepeat(test.frequency, test.weekdays, date)
+			ensureString(t, errString(err), test.wantErr)
+			ensureString(t, rule, test.want)
+			ensureString(t, describeRepeat(rule), test.wantDesc)
+		})
+	}
+}
+
+func TestDescribeRepeat(t *testing.T) {
+	tests := []struct {
+		rule string
+		want string
+	}{
+		{"monthly", "every month"},
+		{"monthly:1", "every month on the 1st"},
+		{"monthly:2", "every month on the 2nd"},
+		{"monthly:3", "every month on the 3rd"},
+		{"monthly:11", "every month on the 11th"},
+		{"monthly:22", "every month on the 22nd"},
+		{"monthly:x", ""},
+	}
+	for _, test := range tests {
+		ensureString(t, describeRepeat(test.rule), test.want)
+	}
+}
+
+func TestRecurringItems(t *testing.T) {
+	server, model := newTestServer(t, nullLogger{}, Config{Timezone: "Pacific/Auckland"})
+	now := time.Date(2021, 9, 10, 12, 0, 0, 0, server.location)
+	server.now = func() time.Time { return now }
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("creating cookie jar: %v", err)
+	}
+	listID, err := model.CreateList("Chores")
+	if err != nil {
+		t.Fatalf("creating list: %v", err)
+	}
+	err = model.AddItems(listID, []*Item{
+		{Description: "Put bins out #bins"},
+		{Description: "Mow lawn"},
+	})
+	if err != nil {
+		t.Fatalf("adding items: %v", err)
+	}
+	itemID := "1"
+	recorder := serve(t, server, jar, "GET", "/lists/"+listID, nil)
+	token := parseForms(t, recorder.Body.String())[0].Inputs["csrf-token"]
+	post := func(path string, form url.Values) {
+		t.Helper()
+		form.Set("csrf-token", token)
+		form.Set("list-id", listID)
+		recorder := serve(t, server, jar, "POST", path, form)
+		ensureRedirect(t, recorder, http.StatusFound, "/lists/"+listID)
+	}
+
+	// Repeat form defaults to the weekday it's due (today if no due date)
+	recorder = serve(t, server, jar, "GET", "/lists/"+listID+"?repeat="+itemID, nil)
+	ensureCode(t, recorder, http.StatusOK)
+	forms := parseForms(t, recorder.Body.String())
+	ensureString(t, forms[0].Action, "/set-repeat")
+	ensureString(t, forms[0].Inputs["item-id"], itemID)
+	if !strings.Contains(recorder.Body.String(), `value="fri" checked>`) {
+		t.Fatalf("due weekday not checked:\n%s", recorder.Body.String())
+	}
+
+	form := url.Values{}
+	form.Set("item-id", itemID)
+	form.Set("repeat", "weekly")
+	form["weekday"] = []string{"tue", "fri"}
+	post("/set-repeat", form)
+	form.Set("repeat", "hourly")
+	recorder = serve(t, server, jar, "POST", "/set-repeat", form)
+	ensureCode(t, recorder, http.StatusBadRequest)
+	form.Set("item-id", "999")
+	recorder = serve(t, server, jar, "POST", "/set-repeat", form)
+	ensureCode(t, recorder, http.StatusNotFound)
+
+	recorder = serve(t, server, jar, "GET", "/lists/"+listID, nil)
+	if !strings.Contains(recorder.Body.String(), `↻ every Tue, Fri</a>`) {
+		t.Fatalf("repeat rule not shown:\n%s", recorder.Body.String())
+	}
+
+	// Marking it done adds a copy due at the next occurrence (with the same
+	// notes and tags)
+	form = url.Values{}
+	form.Set("item-id", itemID)
+	form.Set("notes", "Green bin this week")
+	post("/set-notes", form)
+	form = url.Values{}
+	form.Set("item-id", itemID)
+	form.Set("done", "on")
+	post("/update-done", form)
+	list, err := model.GetList(listID)
+	if err != nil {
+		t.Fatalf("fetching list: %v", err)
+	}
+	ensureInt(t, len(list.Items), 3)
+	ensureString(t, list.Items[0].Repeat, "")
+	next := list.Items[1] // copy is added after the original
+	ensureString(t, next.Description, "Put bins out #bins")
+	ensureString(t, next.Repeat, "weekly:tue,fri")
+	ensureString(t, next.Notes, "Green bin this week")
+	if next.Done || !next.DueAllDay {
+		t.Fatalf("got done %v, all-day %v, want undone all-day item", next.Done, next.DueAllDay)
+	}
+	ensureString(t, next.TimeDue.In(server.location).Format("2006-01-02 15:04"), "2021-09-14 00:00")
+	tagged, err := model.GetTagItems("bins")
+	if err != nil {
+		t.Fatalf("fetching tagged items: %v", err)
+	}
+	ensureInt(t, len(tagged), 1)
+	ensureString(t, tagged[0].ID, next.ID)
+
+	// Unchecking and checking again doesn't add another copy
+	form.Set("done", "")
+	post("/update-done", form)
+	form.Set("done", "on")
+	post("/update-done", form)
+	post("/update-done", form)
+	list, err = model.GetList(listID)
+	if err != nil {
+		t.Fatalf("fetching list: %v", err)
+	}
+	ensureInt(t, len(list.Items), 3)
+
+	// Items without a repeat rule are just marked done
+	form.Set("item-id", list.Items[2].ID)
+	post("/update-done", form)
+	list, err = model.GetList(listID)
+	if err != nil {
+		t.Fatalf("fetching list: %v", err)
+	}
+	ensureInt(t, len(list.Items), 3)
+
+	// Unchecking all leaves done items that have been copied for their next
+	// occurrence, so there's no duplicate
+	recorder = serve(t, server, jar, "GET", "/lists/"+listID+"?uncheck-all=1", nil)
+	if !strings.Contains(recorder.Body.String(), "uncheck the done item?") {
+		t.Fatalf("uncheck not confirmed for one item:\n%s", recorder.Body.String())
+	}
+	post("/uncheck-all-items", url.Values{})
+	list, err = model.GetList(listID)
+	if err != nil {
+		t.Fatalf("fetching list: %v", err)
+	}
+	if !list.Items[0].Done || list.Items[1].Done || list.Items[2].Done {
+		t.Fatalf("got done %v, %v, %v, want true, false, false",
+			list.Items[0].Done, list.Items[1].Done, list.Items[2].Done)
+	}
+}
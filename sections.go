@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// headingPrefix is typed at the start of a description in the add-item form
+// to add a section heading instead of an item, as in Markdown.
+const headingPrefix = "##"
+
+// parseHeading returns the section heading name if description is a heading
+// like "## Produce", otherwise it returns ok false.
+func parseHeading(description string) (name string, ok bool) {
+	if !strings.HasPrefix(description, headingPrefix) {
+		return "", false
+	}
+	name = strings.TrimSpace(strings.TrimLeft(description, "#"))
+	return name, name != ""
+}
+
+// collapseSections returns the items that aren't in collapsed sections, and
+// the number of items hidden in each collapsed section, keyed by heading ID.
+func collapseSections(items []*Item) (visible []*Item, hidden map[string]int) {
+	hidden = make(map[string]int)
+	var collapsed *Item
+	for _, item := range items {
+		if item.Heading {
+			collapsed = nil
+			if item.Collapsed {
+				collapsed = item
+			}
+		} else if collapsed != nil {
+			if item.Depth == 0 {
+				hidden[collapsed.ID]++
+			}
+			continue
+		}
+		visible = append(visible, item)
+	}
+	return visible, hidden
+}
+
+func (s *Server) toggleSection(w http.ResponseWriter, r *http.Request) {
+	listID := r.FormValue("list-id")
+	headingID := r.FormValue("item-id")
+	collapsed := r.FormValue("collapsed") == "on"
+	err := s.model.SetSectionCollapsed(listID, headingID, collapsed)
+	if err != nil {
+		s.internalError(w, r, "updating section", err)
+		return
+	}
+	s.redirect(w, r, "/lists/"+listID)
+}
+
+func (s *Server) moveItem(w http.ResponseWriter, r *http.Request) {
+	listID := r.FormValue("list-id")
+	itemID := r.FormValue("item-id")
+	headingID := r.FormValue("section-id")
+	list, err := s.model.GetList(listID)
+	if err != nil {
+		s.internalError(w, r, "fetching list", err)
+		return
+	}
+	if list == nil {
+		http.NotFound(w, r)
+		return
+	}
+	item := findItem(list.Items, itemID)
+	heading := findItem(list.Items, headingID)
+	if item == nil || item.Heading || (headingID != "" && (heading == nil || !heading.Heading)) {
+		http.NotFound(w, r)
+		return
+	}
+	err = s.model.MoveItemToSection(listID, itemID, headingID)
+	if err != nil {
+		s.internalError(w, r, "moving item", err)
+		return
+	}
+	s.redirect(w, r, "/lists/"+listID)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseHeading(t *testing.T) {
+	tests := []struct {
+		description string
+		want        string
+		wantOK      bool
+	}{
+		{"## Produce", "Produce", true},
+		{"##Dairy & eggs ", "Dairy & eggs", true},
+		{"### Frozen", "Frozen", true},
+		{"##", "", false},
+		{"# Not a heading", "", false},
+		{"Milk ## later", "", false},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			name, ok := parseHeading(test.description)
+			ensureString(t, name, test.want)
+			if ok != test.wantOK {
+				t.Fatalf("got ok %v, want %v", ok, test.wantOK)
+			}
+		})
+	}
+}
+
+func TestSections(t *testing.T) {
+	l := newTestList(t, Config{}, "Shopping")
+	outline := func(lines ...string) string {
+		return strings.Join(lines, "\n")
+	}
+
+	l.addItem("Bags", "")
+	l.addItem("## Produce", "")
+	l.addItem("Apples", "")
+	l.addItem("Bananas", "")
+	l.addItem("## Dairy", "")
+	l.addItem("Milk", "")
+	ensureString(t, l.outline(), outline("[ ] Bags", "## Produce", "[ ] Apples", "[ ] Bananas", "## Dairy", "[ ] Milk"))
+
+	// Headings aren't checkable, and items can be moved to them
+	recorder := l.get("/lists/" + l.id + "?move=1")
+	forms := parseForms(t, recorder.Body.String())
+	ensureString(t, forms[0].Action, "/move-item")
+	ensureString(t, forms[0].Inputs["item-id"], "1")
+	ensureString(t, forms[0].Label, "Move “Bags” to")
+	for _, form := range forms {
+		if form.Action == "/update-done" && (form.Inputs["item-id"] == "2" || form.Inputs["item-id"] == "5") {
+			t.Fatalf("heading is checkable: %+v", form)
+		}
+	}
+
+	form := url.Values{}
+	form.Set("item-id", "1")
+	form.Set("section-id", "2")
+	recorder = l.post("/move-item", form)
+	ensureCode(t, recorder, http.StatusFound)
+	ensureString(t, l.outline(), outline("## Produce", "[ ] Apples", "[ ] Bananas", "[ ] Bags", "## Dairy", "[ ] Milk"))
+	form.Set("item-id", "6")
+	form.Set("section-id", "")
+	l.post("/move-item", form)
+	ensureString(t, l.outline(), outline("[ ] Milk", "## Produce", "[ ] Apples", "[ ] Bananas", "[ ] Bags", "## Dairy"))
+	form.Set("item-id", "3")
+	form.Set("section-id", "5")
+	l.post("/move-item", form)
+	ensureString(t, l.outline(), outline("[ ] Milk", "## Produce", "[ ] Bananas", "[ ] Bags", "## Dairy", "[ ] Apples"))
+	form.Set("section-id", "4") // not a heading
+	recorder = l.post("/move-item", form)
+	ensureCode(t, recorder, http.StatusNotFound)
+
+	// Sub-items move with their parent, and new items are added at the end
+	l.addItem("Green ones", "3")
+	form = url.Values{}
+	form.Set("description", "Green ones")
+	form.Set("parent-id", "2")
+	recorder = l.post("/add-item", form)
+	ensureCode(t, recorder, http.StatusNotFound)
+	form = url.Values{}
+	form.Set("item-id", "3")
+	form.Set("section-id", "2")
+	l.post("/move-item", form)
+	l.addItem("Cheese", "")
+	ensureString(t, l.outline(), outline("[ ] Milk", "## Produce", "[ ] Bananas", "[ ] Bags", "[ ] Apples",
+		"  [ ] Green ones", "## Dairy", "[ ] Cheese"))
+
+	// Collapsing a section hides its items
+	form = url.Values{}
+	form.Set("item-id", "2")
+	form.Set("collapsed", "on")
+	recorder = l.post("/toggle-section", form)
+	ensureCode(t, recorder, http.StatusFound)
+	recorder = l.get("/lists/" + l.id)
+	body := recorder.Body.String()
+	if strings.Contains(body, "Bananas") || strings.Contains(body, "Green ones") ||
+		!strings.Contains(body, "Cheese") || !strings.Contains(body, `<span class="date">3 hidden</span>`) {
+		t.Fatalf("section not collapsed:\n%s", body)
+	}
+	forms = parseForms(t, body)
+	ensureString(t, forms[2].Action, "/toggle-section")
+	ensureString(t, forms[2].Inputs["collapsed"], "")
+	form.Set("collapsed", "")
+	l.post("/toggle-section", form)
+	recorder = l.get("/lists/" + l.id)
+	if !strings.Contains(recorder.Body.String(), "Bananas") {
+		t.Fatalf("section not expanded:\n%s", recorder.Body.String())
+	}
+}
+
+func TestMoveItemOrphans(t *testing.T) {
+	l := newTestList(t, Config{}, "Garden")
+	l.addItem("## Veg", "")
+	l.addItem("Carrots", "")
+	l.addItem("Peas", "")
+	l.addItem("Baby peas", "3")
+	l.addItem("## Fruit", "")
+	l.addItem("Apples", "")
+
+	// A sub-item whose parent was deleted on its own is shown as top-level,
+	// and stays in its section when other items are moved
+	_, err := l.model.db.Exec("UPDATE items SET time_deleted = CURRENT_TIMESTAMP WHERE id = 3")
+	if err != nil {
+		t.Fatalf("deleting item: %v", err)
+	}
+	form := url.Values{}
+	form.Set("item-id", "2")
+	ensureCode(t, l.post("/delete-item", form), http.StatusFound)
+	form = url.Values{}
+	form.Set("item-id", "6")
+	form.Set("section-id", "")
+	ensureRedirect(t, l.post("/move-item", form), http.StatusFound, "/lists/"+l.id)
+	ensureString(t, l.outline(), strings.Join([]string{
+		"[ ] Apples",
+		"## Veg",
+		"[ ] Baby peas",
+		"## Fruit",
+	}, "\n"))
+}
+
+func TestMigratePositions(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`
+		CREATE TABLE items (
+			id INTEGER NOT NULL PRIMARY KEY,
+			list_id INTEGER NOT NULL REFERENCES lists(id),
+			time_created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			description VARCHAR(255) NOT NULL,
+			done BOOLEAN NOT NULL DEFAULT FALSE,
+			time_deleted TIMESTAMP
+		);
+		INSERT INTO items (list_id, description) VALUES ('abc', 'First'), ('abc', 'Second');
+		`)
+	if err != nil {
+		t.Fatalf("creating old schema: %v", err)
+	}
+
+	model, err := NewSQLModel(db)
+	if err != nil {
+		t.Fatalf("creating model: %v", err)
+	}
+	err = model.AddItems("abc", []*Item{{Description: "Third"}})
+	if err != nil {
+		t.Fatalf("adding items: %v", err)
+	}
+	items, err := model.getListItems("abc")
+	if err != nil {
+		t.Fatalf("fetching items: %v", err)
+	}
+	ensureInt(t, len(items), 3)
+	ensureString(t, items[0].Description+", "+items[1].Description+", "+items[2].Description,
+		"First, Second, Third")
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Default security header values. The app doesn't use any scripts, and its
+// only styles are in the served stylesheet.
+const (
+	defaultContentSecurityPolicy = "default-src 'none'; style-src 'self'; img-src 'self'; " +
+		"form-action 'self'; frame-ancestors 'none'; base-uri 'none'"
+	defaultPermissionsPolicy = "camera=(), microphone=(), geolocation=(), payment=(), usb=()"
+)
+
+// securityHeaders returns the security headers to send with every response,
+// as configured. A setting of "off" omits that header.
+func securityHeaders(config Config) (http.Header, error) {
+	header := http.Header{}
+	header.Set("X-Content-Type-Options", "nosniff")
+
+	csp := config.ContentSecurityPolicy
+	if csp == defaultContentSecurityPolicy && config.OIDCIssuer != "" {
+		// Browsers apply form-action to the redirect after the single
+		// sign-on form is submitted, so allow the issuer too.
+		u, err := url.Parse(config.OIDCIssuer)
+		if err == nil {
+			csp = strings.Replace(csp, "form-action 'self'", "form-action 'self' "+u.Scheme+"://"+u.Host, 1)
+		}
+	}
+	values := []struct {
+		name  string
+		value string
+	}{
+		{"Content-Security-Policy", csp},
+		{"X-Frame-Options", strings.ToUpper(config.FrameOptions)},
+		{"Referrer-Policy", config.ReferrerPolicy},
+		{"Permissions-Policy", config.PermissionsPolicy},
+	}
+	for _, v := range values {
+		if v.value != "" && !strings.EqualFold(v.value, "off") {
+			header.Set(v.name, v.value)
+		}
+	}
+
+	maxAge, err := parseHSTSMaxAge(config.HSTSMaxAge)
+	if err != nil {
+		return nil, err
+	}
+	if maxAge > 0 {
+		header.Set("Strict-Transport-Security", "max-age="+strconv.Itoa(int(maxAge/time.Second)))
+	}
+	return header, nil
+}
+
+// parseHSTSMaxAge parses the HSTS max age setting, returning zero if HSTS
+// is off.
+func parseHSTSMaxAge(s string) (time.Duration, error) {
+	if strings.EqualFold(s, "off") {
+		return 0, nil
+	}
+	maxAge, err := parseDuration(s, 0)
+	if err != nil || maxAge < 0 {
+		return 0, fmt.Errorf("hsts_max_age %q invalid (must be a duration like 365d)", s)
+	}
+	return maxAge, nil
+}
+
+// setSecurityHeaders adds the security headers to a response. It's called
+// for every response, including redirects and errors outside the base path.
+func (s *Server) setSecurityHeaders(w http.ResponseWriter) {
+	for name, values := range s.securityHeaders {
+		w.Header()[name] = values
+	}
+}
+
+var stylesheetETag = func() string {
+	sum := sha256.Sum256([]byte(stylesheet))
+	return `"` + hex.EncodeToString(sum[:8]) + `"`
+}()
+
+// showStylesheet serves the app's stylesheet.
+func (s *Server) showStylesheet(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/css; charset=utf-8")
+	w.Header().Set("ETag", stylesheetETag)
+	http.ServeContent(w, r, "style.css", time.Time{}, strings.NewReader(stylesheet))
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	config := DefaultConfig()
+	config.Lists = true
+	config.HSTSMaxAge = "365d"
+	server, _ := newTestServer(t, nullLogger{}, config)
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("creating cookie jar: %v", err)
+	}
+
+	recorder := serve(t, server, jar, "GET", "/", nil)
+	ensureCode(t, recorder, http.StatusOK)
+	header := recorder.Result().Header
+	ensureString(t, header.Get("Content-Security-Policy"), defaultContentSecurityPolicy)
+	ensureString(t, header.Get("X-Frame-Options"), "DENY")
+	ensureString(t, header.Get("Referrer-Policy"), "same-origin")
+	ensureString(t, header.Get("X-Content-Type-Options"), "nosniff")
+	ensureString(t, header.Get("Permissions-Policy"), defaultPermissionsPolicy)
+	ensureString(t, header.Get("Strict-Transport-Security"), "max-age=31536000")
+
+	// The CSP forbids inline styles, so pages mustn't use them
+	for _, tmpl := range []string{homeTmpl, listTmpl, agendaTmpl, sessionsTmpl, redirectTmpl} {
+		if strings.Contains(tmpl, "style=") {
+			t.Errorf("template uses inline style:\n%s", tmpl)
+		}
+	}
+	recorder = serve(t, server, jar, "GET", "/static/style.css", nil)
+	ensureCode(t, recorder, http.StatusOK)
+	ensureString(t, recorder.Result().Header.Get("Content-Type"), "text/css; charset=utf-8")
+	ensureString(t, recorder.Body.String(), stylesheet)
+	r := httptest.NewRequest("GET", "/static/style.css", nil)
+	r.Header.Set("If-None-Match", recorder.Result().Header.Get("ETag"))
+	recorder = httptest.NewRecorder()
+	server.ServeHTTP(recorder, r)
+	ensureCode(t, recorder, http.StatusNotModified)
+
+	// Headers are also sent outside the base path
+	basedConfig := DefaultConfig()
+	basedConfig.BasePath = "/lists-app/"
+	based, _ := newTestServer(t, nullLogger{}, basedConfig)
+	for _, path := range []string{"/lists-app", "/other"} {
+		recorder = serve(t, based, jar, "GET", path, nil)
+		header = recorder.Result().Header
+		ensureString(t, header.Get("Content-Security-Policy"), defaultContentSecurityPolicy)
+		ensureString(t, header.Get("X-Content-Type-Options"), "nosniff")
+	}
+
+	// Headers can be turned off, and single sign-on issuer is allowed as a
+	// form action
+	config.FrameOptions = "off"
+	config.ReferrerPolicy = "off"
+	config.HSTSMaxAge = ""
+	config.OIDCIssuer = "https://accounts.example.com/"
+	header, err = securityHeaders(config)
+	if err != nil {
+		t.Fatalf("getting security headers: %v", err)
+	}
+	ensureString(t, header.Get("X-Frame-Options"), "")
+	ensureString(t, header.Get("Referrer-Policy"), "")
+	ensureString(t, header.Get("Strict-Transport-Security"), "")
+	if !strings.Contains(header.Get("Content-Security-Policy"), "form-action 'self' https://accounts.example.com;") {
+		t.Fatalf("issuer not allowed in CSP: %s", header.Get("Content-Security-Policy"))
+	}
+}
@@ -1,18 +1,25 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/hex"
-	"errors"
 	"html/template"
+	"net"
 	"net/http"
 	"net/url"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
-
-	"golang.org/x/crypto/bcrypt"
 )
 
+// pendingSignInExpiry is how long the user has to enter their TOTP code
+// after entering their password.
+const pendingSignInExpiry = 5 * time.Minute
+
 // Server is the HTTP server for the to-do list app.
 type Server struct {
 	model        Model
@@ -21,10 +28,39 @@ type Server struct {
 	username     string
 	passwordHash string
 	showLists    bool
+	metricsToken string
+	basePath     string // URL path prefix without trailing slash, eg "/lists-app"
+	publicOrigin string // scheme and host forms must come from, "" to use the request's Host
+	now          func() time.Time
+
+	sessionLifetime      time.Duration
+	sessionIdleTimeout   time.Duration // zero if no idle timeout
+	sessionRenewInterval time.Duration
+
+	totpKey        []byte   // nil if TOTP not enabled
+	recoveryHashes []string // hashes of TOTP recovery codes
+
+	signInMu        sync.Mutex     // guards signInsInFlight
+	signInsInFlight map[string]int // sign-in attempts in progress, by throttling key
+
+	authHeader      string       // header set by trusted proxy, "" if not enabled
+	trustedProxies  []*net.IPNet // proxies allowed to set authHeader and X-Forwarded-* headers
+	trustUnixSocket bool         // proxy connecting over the Unix socket may set authHeader
+	provisioned     sync.Map     // usernames already provisioned (string -> bool)
+
+	oidc *oidcProvider // nil if OpenID Connect sign-in not enabled
+
+	securityHeaders http.Header
+	csrfKey         []byte // key for signing CSRF tokens
 
-	mux      *http.ServeMux
-	homeTmpl *template.Template
-	listTmpl *template.Template
+	mux          *http.ServeMux
+	metrics      *metrics
+	homeTmpl     *template.Template
+	redirectTmpl *template.Template
+	errorTmpl    *template.Template
+	listTmpl     *template.Template
+	agendaTmpl   *template.Template
+	sessionsTmpl *template.Template
 }
 
 // Model is the database model interface used by the server.
@@ -32,47 +68,131 @@ type Model interface {
 	GetLists() ([]*List, error)
 	CreateList(name string) (string, error)
 	DeleteList(id string) error
+	CopyList(id, name string) (string, error)
+	SetListTemplate(id string, template bool) error
 	GetList(id string) (*List, error)
 
-	AddItem(listID, description string) (string, error)
-	UpdateDone(listID, itemID string, done bool) error
+	UpdateDone(listID, itemID string, done bool, now time.Time) error
+	SetItemDue(listID, itemID string, due time.Time, allDay bool) error
+	SetItemRepeat(listID, itemID, rule string) error
+	SetItemNotes(listID, itemID, notes string) error
+	AddHeading(listID, name string) (string, error)
+	AddItems(listID string, items []*Item) error
+	SetSectionCollapsed(listID, headingID string, collapsed bool) error
+	MoveItemToSection(listID, itemID, headingID string) error
+	UpdateChildrenDone(listID, itemID string, done bool) error
+	GetDueItems(before time.Time) ([]*ListItem, error)
+	GetTagItems(tag string) ([]*ListItem, error)
 	DeleteItem(listID, itemID string) error
+	DeleteDoneItems(listID string) error
+	UncheckAllItems(listID string) error
 
-	CreateSignIn() (string, error)
-	IsSignInValid(id string) (bool, error)
+	CreateSignIn(username, userAgent, ip string, now time.Time) (string, error)
+	GetSignIn(id string) (*SignIn, error)
 	DeleteSignIn(id string) error
-}
+	TouchSignIn(id string, now time.Time) error
+	GetSignIns(currentID string, createdAfter, lastSeenAfter time.Time) ([]*SignIn, error)
+	DeleteSignInByKey(currentID, key string) error
+	DeleteAllSignIns(currentID string) error
+
+	GetSignInFailures(key string) (int, time.Time, error)
+	RecordSignInFailure(key string, now, resetBefore time.Time) error
+	ClearSignInFailures(key string) error
+
+	CreatePendingSignIn(now time.Time) (string, error)
+	IsPendingSignInValid(id string, createdAfter time.Time) (bool, error)
+	DeletePendingSignIn(id string) error
+	UseSecondFactor(value string, now time.Time) (bool, error)
+
+	ProvisionUser(username string, now time.Time) (bool, error)
+	EnsureSecret(name string, value []byte) ([]byte, error)
 
-// Logger is the logger interface used by the server.
-type Logger interface {
-	Printf(format string, v ...interface{})
+	GetStats(signInsCreatedAfter, signInsLastSeenAfter time.Time) (*Stats, error)
 }
 
-// NewServer creates a new server with the specified dependencies.
-func NewServer(
-	model Model,
-	logger Logger,
-	timezone string,
-	username string,
-	passwordHash string,
-	showLists bool,
-) (*Server, error) {
+// NewServer creates a new server with the specified dependencies and
+// configuration.
+func NewServer(model Model, logger Logger, config Config) (*Server, error) {
 	location := time.Local // use server's local time if timezone not specified
-	if timezone != "" {
+	if config.Timezone != "" {
+		var err error
+		location, err = time.LoadLocation(config.Timezone)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var totpKey []byte
+	if config.TOTPSecret != "" {
 		var err error
-		location, err = time.LoadLocation(timezone)
+		totpKey, err = ParseTOTPSecret(config.TOTPSecret)
+		if err != nil {
+			return nil, err
+		}
+	}
+	recoveryHashes, err := ParseRecoveryHashes(config.TOTPRecovery)
+	if err != nil {
+		return nil, err
+	}
+	trustedProxies, trustUnixSocket, err := ParseTrustedProxies(config.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	securityHeaders, err := securityHeaders(config)
+	if err != nil {
+		return nil, err
+	}
+	sessionLifetime, sessionIdleTimeout, sessionRenewInterval, err := config.sessionDurations()
+	if err != nil {
+		return nil, err
+	}
+
+	var publicOrigin string
+	if config.PublicURL != "" {
+		u, err := url.Parse(config.PublicURL)
 		if err != nil {
 			return nil, err
 		}
+		publicOrigin = u.Scheme + "://" + u.Host
 	}
+
+	metrics := newMetrics()
 	s := &Server{
-		model:        model,
+		model:        &metricsModel{model, metrics},
 		logger:       logger,
 		location:     location,
-		username:     username,
-		passwordHash: passwordHash,
-		showLists:    showLists,
+		username:     config.Username,
+		passwordHash: config.PassHash,
+		showLists:    config.Lists,
+		metricsToken: config.MetricsToken,
+		basePath:     strings.TrimRight(config.BasePath, "/"),
+		publicOrigin: publicOrigin,
+		now:          time.Now,
 		mux:          http.NewServeMux(),
+		metrics:      metrics,
+
+		sessionLifetime:      sessionLifetime,
+		sessionIdleTimeout:   sessionIdleTimeout,
+		sessionRenewInterval: sessionRenewInterval,
+
+		totpKey:        totpKey,
+		recoveryHashes: recoveryHashes,
+
+		signInsInFlight: make(map[string]int),
+
+		authHeader:      config.AuthHeader,
+		trustedProxies:  trustedProxies,
+		trustUnixSocket: trustUnixSocket,
+
+		oidc: newOIDCProvider(config),
+
+		securityHeaders: securityHeaders,
+	}
+	s.csrfKey, err = s.loadCSRFKey(config.CSRFSecret)
+	if err != nil {
+		return nil, err
+	}
+	if config.PassHash != "" && isWeakPasswordHash(config.PassHash) {
+		logger.Log(LevelWarn, "password hash uses weaker parameters than the current defaults, generate a new one with -genpass")
 	}
 	s.addRoutes()
 	s.addTemplates()
@@ -87,37 +207,138 @@ func (s *Server) addRoutes() {
 			http.NotFound(w, r)
 		}
 	})
-	s.mux.HandleFunc("/sign-in", csrf(s.signIn))
-	s.mux.HandleFunc("/sign-out", s.signedIn(csrf(s.signOut)))
+	s.mux.HandleFunc("/sign-in", s.csrf(s.signIn))
+	s.mux.HandleFunc("/sign-in-totp", s.csrf(s.signInTOTP))
+	s.mux.HandleFunc("/sign-in-oidc", s.csrf(s.oidcSignIn))
+	s.mux.HandleFunc("/oidc-callback", s.oidcCallback)
+	s.mux.HandleFunc("/sign-out", s.signedIn(s.csrf(s.signOut)))
 	s.mux.HandleFunc("/lists/", s.signedIn(s.showList))
-	s.mux.HandleFunc("/create-list", s.signedIn(csrf(s.createList)))
-	s.mux.HandleFunc("/delete-list", s.signedIn(csrf(s.deleteList)))
-	s.mux.HandleFunc("/add-item", s.signedIn(csrf(s.addItem)))
-	s.mux.HandleFunc("/update-done", s.signedIn(csrf(s.updateDone)))
-	s.mux.HandleFunc("/delete-item", s.signedIn(csrf(s.deleteItem)))
+	s.mux.HandleFunc("/create-list", s.signedIn(s.csrf(s.createList)))
+	s.mux.HandleFunc("/delete-list", s.signedIn(s.csrf(s.deleteList)))
+	s.mux.HandleFunc("/duplicate-list", s.signedIn(s.csrf(s.duplicateList)))
+	s.mux.HandleFunc("/set-template", s.signedIn(s.csrf(s.setTemplate)))
+	s.mux.HandleFunc("/add-item", s.signedIn(s.csrf(s.addItem)))
+	s.mux.HandleFunc("/add-items", s.signedIn(s.csrf(s.addItems)))
+	s.mux.HandleFunc("/update-done", s.signedIn(s.csrf(s.updateDone)))
+	s.mux.HandleFunc("/complete-children", s.signedIn(s.csrf(s.completeChildren)))
+	s.mux.HandleFunc("/toggle-section", s.signedIn(s.csrf(s.toggleSection)))
+	s.mux.HandleFunc("/move-item", s.signedIn(s.csrf(s.moveItem)))
+	s.mux.HandleFunc("/set-repeat", s.signedIn(s.csrf(s.setRepeat)))
+	s.mux.HandleFunc("/set-due", s.signedIn(s.csrf(s.setDue)))
+	s.mux.HandleFunc("/set-notes", s.signedIn(s.csrf(s.setNotes)))
+	s.mux.HandleFunc("/delete-item", s.signedIn(s.csrf(s.deleteItem)))
+	s.mux.HandleFunc("/delete-done-items", s.signedIn(s.csrf(s.deleteDoneItems)))
+	s.mux.HandleFunc("/uncheck-all-items", s.signedIn(s.csrf(s.uncheckAllItems)))
+	s.mux.HandleFunc("/today", s.signedIn(s.showToday))
+	s.mux.HandleFunc("/upcoming", s.signedIn(s.showUpcoming))
+	s.mux.HandleFunc("/tags/", s.signedIn(s.showTag))
+	s.mux.HandleFunc("/sessions", s.signedIn(s.showSessions))
+	s.mux.HandleFunc("/revoke-session", s.signedIn(s.csrf(s.revokeSession)))
+	s.mux.HandleFunc("/sign-out-everywhere", s.signedIn(s.csrf(s.signOutEverywhere)))
+	s.mux.HandleFunc("/metrics", s.showMetrics)
+	s.mux.HandleFunc("/static/style.css", s.showStylesheet)
 }
 
 func (s *Server) signedIn(h http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if !s.isSignedIn(r) {
-			location := "/?return-url=" + url.QueryEscape(r.URL.Path)
-			http.Redirect(w, r, location, http.StatusFound)
+		if !s.isSignedIn(w, r) {
+			if s.authHeader != "" {
+				// Signing in is up to the proxy, nothing we can do
+				http.Error(w, "401 not authenticated by proxy", http.StatusUnauthorized)
+				return
+			}
+			s.redirect(w, r, "/?return-url="+url.QueryEscape(r.URL.Path))
 			return
 		}
 		h(w, r)
 	}
 }
 
-func (s *Server) isSignedIn(r *http.Request) bool {
-	if s.username == "" {
+// isSignedIn reports whether the request has a valid sign-in. If an idle
+// timeout is configured, it also renews the sign-in (and its cookie). In
+// proxy auth mode, it checks the trusted proxy's auth header instead.
+func (s *Server) isSignedIn(w http.ResponseWriter, r *http.Request) bool {
+	if s.authHeader != "" {
+		return s.isProxySignedIn(r)
+	}
+	if !s.signInRequired() {
 		return true
 	}
-	valid, err := s.model.IsSignInValid(getSignInCookie(r))
-	return err == nil && valid
+	id := getSignInCookie(r)
+	signIn, err := s.model.GetSignIn(id)
+	if err != nil || signIn == nil {
+		return false
+	}
+	createdAfter, lastSeenAfter := s.signInCutoffs()
+	if !signIn.TimeCreated.After(createdAfter) || !signIn.TimeLastSeen.After(lastSeenAfter) {
+		return false
+	}
+	getRequestInfo(r).user = s.username
+	if signIn.Username != "" {
+		getRequestInfo(r).user = signIn.Username
+	}
+
+	now := s.now()
+	if now.Sub(signIn.TimeLastSeen) >= s.sessionRenewInterval {
+		err = s.model.TouchSignIn(id, now)
+		if err != nil {
+			s.logger.Log(LevelError, "error updating sign in", "error", err,
+				"request_id", getRequestInfo(r).id)
+			return true
+		}
+		if s.sessionIdleTimeout > 0 {
+			s.setSignInCookie(w, r, id, signIn.TimeCreated)
+		}
+	}
+	return true
+}
+
+// signInRequired reports whether users need to sign in, either with a
+// username and password or with OpenID Connect.
+func (s *Server) signInRequired() bool {
+	return s.username != "" || s.oidc != nil
+}
+
+// signInCutoffs returns the creation and last-seen times before which
+// sign-ins have expired. If there's no idle timeout, lastSeenAfter is zero.
+func (s *Server) signInCutoffs() (createdAfter, lastSeenAfter time.Time) {
+	now := s.now()
+	createdAfter = now.Add(-s.sessionLifetime)
+	if s.sessionIdleTimeout > 0 {
+		lastSeenAfter = now.Add(-s.sessionIdleTimeout)
+	}
+	return createdAfter, lastSeenAfter
+}
+
+// setSignInCookie sets the sign-in cookie for the given sign-in, expiring
+// at the same time as the sign-in itself (assuming it was just used).
+func (s *Server) setSignInCookie(w http.ResponseWriter, r *http.Request, id string, timeCreated time.Time) {
+	expires := timeCreated.Add(s.sessionLifetime)
+	if s.sessionIdleTimeout > 0 {
+		idleExpires := s.now().Add(s.sessionIdleTimeout)
+		if idleExpires.Before(expires) {
+			expires = idleExpires
+		}
+	}
+	cookie := &http.Cookie{
+		Name:     "sign-in",
+		Value:    id,
+		MaxAge:   int(expires.Sub(s.now()) / time.Second),
+		Path:     s.cookiePath(),
+		Secure:   r.URL.Scheme == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}
+	http.SetCookie(w, cookie)
 }
 
 func getSignInCookie(r *http.Request) string {
-	cookie, err := r.Cookie("sign-in")
+	return getCookie(r, "sign-in")
+}
+
+// getCookie returns the value of the named cookie, or "" if not present.
+func getCookie(r *http.Request, name string) string {
+	cookie, err := r.Cookie(name)
 	if err != nil {
 		return ""
 	}
@@ -125,16 +346,147 @@ func getSignInCookie(r *http.Request) string {
 }
 
 func (s *Server) addTemplates() {
-	s.homeTmpl = template.Must(template.New("home").Parse(homeTmpl))
-	s.listTmpl = template.Must(template.New("list").Parse(listTmpl))
+	funcs := template.FuncMap{
+		// url returns the concatenation of its arguments, prefixed with the
+		// base path, for example {{ url "/lists/" .ID }}.
+		"url": func(parts ...string) string {
+			return s.basePath + strings.Join(parts, "")
+		},
+		"dueClass":       s.dueClass,
+		"describeRepeat": describeRepeat,
+		"linkify":        linkify,
+		"tagLinks":       s.tagLinks,
+	}
+	s.homeTmpl = template.Must(template.New("home").Funcs(funcs).Parse(homeTmpl))
+	s.redirectTmpl = template.Must(template.New("redirect").Funcs(funcs).Parse(redirectTmpl))
+	s.errorTmpl = template.Must(template.New("error").Funcs(funcs).Parse(errorTmpl))
+	s.listTmpl = template.Must(template.New("list").Funcs(funcs).Parse(listTmpl))
+	s.agendaTmpl = template.Must(template.New("agenda").Funcs(funcs).Parse(agendaTmpl))
+	s.sessionsTmpl = template.Must(template.New("sessions").Funcs(funcs).Parse(sessionsTmpl))
+}
+
+// redirect redirects to the given path (which is relative to the base path).
+func (s *Server) redirect(w http.ResponseWriter, r *http.Request, path string) {
+	http.Redirect(w, r, s.basePath+path, http.StatusFound)
+}
+
+// cookiePath returns the path to use for cookies.
+func (s *Server) cookiePath() string {
+	return s.basePath + "/"
 }
 
 // ServeHTTP implements the http.Handler interface.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
+	info := &requestInfo{id: generateRequestID()}
+	r = r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info))
 	w.Header().Set("Cache-Control", "no-cache")
-	s.mux.ServeHTTP(w, r)
-	s.logger.Printf("%s %s %v", r.Method, r.URL.Path, time.Since(startTime))
+	w.Header().Set("X-Request-ID", info.id)
+	s.setSecurityHeaders(w)
+
+	path := r.URL.Path
+	sw := &statusWriter{ResponseWriter: w}
+	var route string
+	switch {
+	case s.basePath == "" || strings.HasPrefix(path, s.basePath+"/"):
+		r = stripPrefix(r, s.basePath)
+		_, route = s.mux.Handler(r)
+		s.mux.ServeHTTP(sw, r)
+	case path == s.basePath:
+		http.Redirect(sw, r, s.basePath+"/", http.StatusFound)
+	default:
+		http.NotFound(sw, r)
+	}
+	duration := time.Since(startTime)
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+
+	s.metrics.observeRequest(route, sw.status, duration)
+	s.logger.Log(LevelInfo, "request",
+		"method", r.Method,
+		"path", path,
+		"status", sw.status,
+		"bytes", sw.size,
+		"duration_ms", float64(duration.Microseconds())/1000,
+		"remote", clientIP(r),
+		"request_id", info.id,
+		"user", info.user)
+}
+
+// stripPrefix returns a shallow copy of r with prefix removed from the
+// start of its URL path.
+func stripPrefix(r *http.Request, prefix string) *http.Request {
+	if prefix == "" {
+		return r
+	}
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = strings.TrimPrefix(r.URL.Path, prefix)
+	r2.URL.RawPath = ""
+	return r2
+}
+
+// requestInfo holds per-request information used for access logging.
+type requestInfo struct {
+	id   string
+	user string
+}
+
+type requestInfoKey struct{}
+
+// getRequestInfo returns the request's requestInfo (set by ServeHTTP).
+func getRequestInfo(r *http.Request) *requestInfo {
+	info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo)
+	if !ok {
+		// Not called via ServeHTTP, return a throwaway one
+		return &requestInfo{}
+	}
+	return info
+}
+
+func generateRequestID() string {
+	b := make([]byte, 8)
+	_, err := rand.Read(b)
+	if err != nil { // should never fail
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// clientIP returns the IP address of the client that made the request.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// statusWriter is an http.ResponseWriter that records the response status
+// and the number of bytes written.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
 }
 
 func (s *Server) home(w http.ResponseWriter, r *http.Request) {
@@ -143,7 +495,7 @@ func (s *Server) home(w http.ResponseWriter, r *http.Request) {
 		var err error
 		lists, err = s.model.GetLists()
 		if err != nil {
-			s.internalError(w, "fetching lists", err)
+			s.internalError(w, r, "fetching lists", err)
 			return
 		}
 		for _, list := range lists {
@@ -152,64 +504,242 @@ func (s *Server) home(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	isSignedIn := s.isSignedIn(r)
+	isSignedIn := s.isSignedIn(w, r)
+	if !isSignedIn && s.authHeader != "" {
+		http.Error(w, "401 not authenticated by proxy", http.StatusUnauthorized)
+		return
+	}
+	showTOTP := !isSignedIn && s.isPendingSignIn(r)
 	var data = struct {
-		Token       string
-		Lists       []*List
-		ShowSignIn  bool
-		ShowSignOut bool
-		ReturnURL   string
-		SignInError bool
+		Token        string
+		Lists        []*List
+		Templates    []*List
+		ShowSignIn   bool
+		ShowPassword bool
+		ShowOIDC     bool
+		ShowSignOut  bool
+		ShowAgenda   bool
+		ReturnURL    string
+		SignInError  bool
+		SignInLocked bool
+		ShowTOTP     bool
+		TOTPError    bool
+		OIDCError    bool
 	}{
-		Token:       getCSRFToken(w, r),
-		Lists:       lists,
-		ShowSignIn:  !isSignedIn,
-		ShowSignOut: s.username != "" && isSignedIn,
-		ReturnURL:   r.URL.Query().Get("return-url"),
-		SignInError: r.URL.Query().Get("error") == "sign-in",
+		Token:        s.getCSRFToken(w, r),
+		Lists:        lists,
+		Templates:    templateLists(lists),
+		ShowSignIn:   !isSignedIn,
+		ShowPassword: s.username != "",
+		ShowOIDC:     s.oidc != nil,
+		ShowSignOut:  s.signInRequired() && isSignedIn,
+		ShowAgenda:   s.showLists && isSignedIn,
+		ReturnURL:    r.URL.Query().Get("return-url"),
+		SignInError:  r.URL.Query().Get("error") == "sign-in",
+		SignInLocked: r.URL.Query().Get("error") == "locked",
+		ShowTOTP:     showTOTP,
+		TOTPError:    r.URL.Query().Get("error") == "totp",
+		OIDCError:    r.URL.Query().Get("error") == "oidc",
 	}
 	err := s.homeTmpl.Execute(w, data)
 	if err != nil {
-		s.internalError(w, "rendering template", err)
+		s.internalError(w, r, "rendering template", err)
 		return
 	}
 }
 
 func (s *Server) signIn(w http.ResponseWriter, r *http.Request) {
+	if s.username == "" {
+		// Password sign-in not enabled (no auth, proxy auth, or OIDC only)
+		http.NotFound(w, r)
+		return
+	}
 	username := strings.TrimSpace(r.FormValue("username"))
 	password := r.FormValue("password")
 	returnURL := r.FormValue("return-url")
 	if returnURL == "" {
 		returnURL = "/"
 	}
-	if username != s.username || bcrypt.CompareHashAndPassword([]byte(s.passwordHash), []byte(password)) != nil {
-		location := "/?error=sign-in&return-url=" + url.QueryEscape(returnURL)
-		http.Redirect(w, r, location, http.StatusFound)
+
+	ip := clientIP(r)
+	keys := s.signInKeys(s.signInIP(r), username)
+	lockedUntil, err := s.startSignInAttempt(keys)
+	if err != nil {
+		s.internalError(w, r, "checking sign in failures", err)
+		return
+	}
+	if s.now().Before(lockedUntil) {
+		s.logger.Log(LevelWarn, "sign in locked out", "username", username, "remote", ip,
+			"locked_until", lockedUntil.UTC().Format(time.RFC3339), "request_id", getRequestInfo(r).id)
+		s.redirect(w, r, "/?error=locked&return-url="+url.QueryEscape(returnURL))
 		return
 	}
-	id, err := s.model.CreateSignIn()
+
+	ok := username == s.username && checkPassword(s.passwordHash, password)
+	err = s.finishSignInAttempt(keys, !ok)
 	if err != nil {
-		s.internalError(w, "creating sign in", err)
+		s.internalError(w, r, "recording sign in failure", err)
+		return
+	}
+	if !ok {
+		s.metrics.incFailedSignIns()
+		s.logger.Log(LevelWarn, "sign in failed", "username", username, "remote", ip,
+			"request_id", getRequestInfo(r).id)
+		s.redirect(w, r, "/?error=sign-in&return-url="+url.QueryEscape(returnURL))
+		return
+	}
+	if s.totpKey != nil {
+		// Password is correct, but user still needs to enter a TOTP code
+		pendingID, err := s.model.CreatePendingSignIn(s.now())
+		if err != nil {
+			s.internalError(w, r, "creating pending sign in", err)
+			return
+		}
+		cookie := &http.Cookie{
+			Name:     "sign-in-pending",
+			Value:    pendingID,
+			MaxAge:   int(pendingSignInExpiry / time.Second),
+			Path:     s.cookiePath(),
+			Secure:   r.URL.Scheme == "https",
+			HttpOnly: true,
+			SameSite: http.SameSiteStrictMode,
+		}
+		http.SetCookie(w, cookie)
+		s.redirect(w, r, "/?return-url="+url.QueryEscape(returnURL))
+		return
+	}
+
+	s.completeSignIn(w, r, keys, returnURL)
+}
+
+// signInTOTP handles the second sign-in step when TOTP is enabled, checking
+// the TOTP code or recovery code entered by the user.
+func (s *Server) signInTOTP(w http.ResponseWriter, r *http.Request) {
+	returnURL := r.FormValue("return-url")
+	if returnURL == "" {
+		returnURL = "/"
+	}
+	pendingID := getCookie(r, "sign-in-pending")
+	if !s.isPendingSignIn(r) {
+		// Pending sign-in expired, go back to username/password step
+		s.redirect(w, r, "/?return-url="+url.QueryEscape(returnURL))
+		return
+	}
+
+	ip := clientIP(r)
+	keys := s.signInKeys(s.signInIP(r), s.username)
+	lockedUntil, err := s.startSignInAttempt(keys)
+	if err != nil {
+		s.internalError(w, r, "checking sign in failures", err)
+		return
+	}
+	if s.now().Before(lockedUntil) {
+		s.logger.Log(LevelWarn, "sign in locked out", "username", s.username, "remote", ip,
+			"locked_until", lockedUntil.UTC().Format(time.RFC3339), "request_id", getRequestInfo(r).id)
+		s.redirect(w, r, "/?error=locked&return-url="+url.QueryEscape(returnURL))
+		return
+	}
+
+	code := strings.Replace(strings.TrimSpace(r.FormValue("code")), " ", "", -1)
+	ok, err := s.checkSecondFactor(r, code)
+	if err != nil {
+		s.finishSignInAttempt(keys, false)
+		s.internalError(w, r, "checking TOTP code", err)
+		return
+	}
+	err = s.finishSignInAttempt(keys, !ok)
+	if err != nil {
+		s.internalError(w, r, "recording sign in failure", err)
+		return
+	}
+	if !ok {
+		s.metrics.incFailedSignIns()
+		s.logger.Log(LevelWarn, "sign in TOTP failed", "username", s.username, "remote", ip,
+			"request_id", getRequestInfo(r).id)
+		s.redirect(w, r, "/?error=totp&return-url="+url.QueryEscape(returnURL))
+		return
+	}
+	err = s.model.DeletePendingSignIn(pendingID)
+	if err != nil {
+		s.internalError(w, r, "deleting pending sign in", err)
 		return
 	}
 	cookie := &http.Cookie{
-		Name:     "sign-in",
-		Value:    id,
-		MaxAge:   90 * 24 * 60 * 60,
-		Path:     "/",
+		Name:     "sign-in-pending",
+		MaxAge:   -1,
+		Path:     s.cookiePath(),
 		Secure:   r.URL.Scheme == "https",
 		HttpOnly: true,
 		SameSite: http.SameSiteStrictMode,
 	}
 	http.SetCookie(w, cookie)
-	http.Redirect(w, r, returnURL, http.StatusFound)
+
+	s.completeSignIn(w, r, keys, returnURL)
+}
+
+// isPendingSignIn reports whether the request has a valid (unexpired)
+// pending sign-in that's waiting for a TOTP code.
+func (s *Server) isPendingSignIn(r *http.Request) bool {
+	if s.totpKey == nil {
+		return false
+	}
+	createdAfter := s.now().Add(-pendingSignInExpiry)
+	valid, err := s.model.IsPendingSignInValid(getCookie(r, "sign-in-pending"), createdAfter)
+	return err == nil && valid
+}
+
+// checkSecondFactor reports whether code is a valid TOTP code or recovery
+// code. Each TOTP code and recovery code can only be used once.
+func (s *Server) checkSecondFactor(r *http.Request, code string) (bool, error) {
+	now := s.now()
+	if counter, ok := verifyTOTP(s.totpKey, code, now); ok {
+		return s.model.UseSecondFactor("totp:"+strconv.FormatUint(counter, 10), now)
+	}
+	hash := hashRecoveryCode(code)
+	for _, recoveryHash := range s.recoveryHashes {
+		if subtle.ConstantTimeCompare([]byte(hash), []byte(recoveryHash)) == 1 {
+			s.logger.Log(LevelWarn, "recovery code used", "username", s.username,
+				"request_id", getRequestInfo(r).id)
+			return s.model.UseSecondFactor("recovery:"+hash, now)
+		}
+	}
+	return false, nil
+}
+
+// completeSignIn clears the sign-in failures for keys, creates a new sign-in,
+// sets the sign-in cookie, and redirects to returnURL. Failures are only
+// cleared here, once all sign-in steps have succeeded, so that a correct
+// password doesn't reset the lockout for TOTP codes.
+func (s *Server) completeSignIn(w http.ResponseWriter, r *http.Request, keys []string, returnURL string) {
+	err := s.clearSignInFailures(keys)
+	if err != nil {
+		s.internalError(w, r, "clearing sign in failures", err)
+		return
+	}
+	err = s.createSignIn(w, r, s.username)
+	if err != nil {
+		s.internalError(w, r, "creating sign in", err)
+		return
+	}
+	s.redirect(w, r, returnURL)
+}
+
+// createSignIn creates a new sign-in for username and sets the sign-in cookie.
+func (s *Server) createSignIn(w http.ResponseWriter, r *http.Request, username string) error {
+	now := s.now()
+	id, err := s.model.CreateSignIn(username, r.UserAgent(), clientIP(r), now)
+	if err != nil {
+		return err
+	}
+	s.setSignInCookie(w, r, id, now)
+	return nil
 }
 
 func (s *Server) signOut(w http.ResponseWriter, r *http.Request) {
 	cookie := &http.Cookie{
 		Name:     "sign-in",
 		MaxAge:   -1,
-		Path:     "/",
+		Path:     s.cookiePath(),
 		Secure:   r.URL.Scheme == "https",
 		HttpOnly: true,
 		SameSite: http.SameSiteStrictMode,
@@ -218,37 +748,153 @@ func (s *Server) signOut(w http.ResponseWriter, r *http.Request) {
 
 	err := s.model.DeleteSignIn(getSignInCookie(r))
 	if err != nil {
-		s.internalError(w, "deleting sign in", err)
+		s.internalError(w, r, "deleting sign in", err)
 		return
 	}
 
-	http.Redirect(w, r, "/", http.StatusFound)
+	s.redirect(w, r, "/")
 }
 
 func (s *Server) showList(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Path[len("/lists/"):]
 	list, err := s.model.GetList(id)
 	if err != nil {
-		s.internalError(w, "fetching list", err)
+		s.internalError(w, r, "fetching list", err)
 		return
 	}
 	if list == nil {
 		http.NotFound(w, r)
 		return
 	}
+	hasDue := false
+	for _, item := range list.Items {
+		if !item.TimeDue.IsZero() {
+			// Change UTC timezone to display timezone
+			item.TimeDue = item.TimeDue.In(s.location)
+			hasDue = true
+		}
+	}
+	query := r.URL.Query()
+	var completeChildren *Item
+	var undoneChildren int
+	if item := findItem(list.Items, query.Get("complete-children")); item != nil && item.Done {
+		// Must be done before sorting, as it relies on the nested order
+		for _, child := range descendants(list.Items, item) {
+			if !child.Done {
+				completeChildren = item
+				undoneChildren++
+			}
+		}
+	}
+	var headings []*Item
+	var doneCount, uncheckCount int
+	for _, item := range list.Items {
+		if item.Heading {
+			headings = append(headings, item)
+		} else if item.Done {
+			doneCount++
+			if !item.Repeated {
+				uncheckCount++
+			}
+		}
+	}
+	var hiddenCounts map[string]int
+	sortByDue := query.Get("sort") == "due"
+	if sortByDue {
+		// Items with due dates first, earliest first, then the rest in order
+		// (not nested or in sections, as they're no longer in order)
+		var items []*Item
+		for _, item := range list.Items {
+			if !item.Heading {
+				item.Depth = 0
+				items = append(items, item)
+			}
+		}
+		sort.SliceStable(items, func(i, j int) bool {
+			a, b := items[i].TimeDue, items[j].TimeDue
+			return !a.IsZero() && (b.IsZero() || a.Before(b))
+		})
+		list.Items = items
+	} else {
+		list.Items, hiddenCounts = collapseSections(list.Items)
+	}
 
 	var data = struct {
-		Token      string
-		List       *List
-		ShowDelete bool
+		Token            string
+		List             *List
+		ShowDelete       bool
+		ShowBulk         bool
+		ShowDeleteDone   bool
+		ShowUncheckAll   bool
+		CopyName         string
+		ShowTemplate     bool
+		EditTemplate     bool
+		DoneCount        int
+		UncheckCount     int
+		HasDue           bool
+		SortByDue        bool
+		EditDue          *Item
+		DueDate          string
+		DueTime          string
+		EditRepeat       *Item
+		RepeatFrequency  string
+		RepeatWeekdays   []weekdayOption
+		EditNotes        *Item
+		AddUnder         *Item
+		CompleteChildren *Item
+		UndoneChildren   int
+		MaxDepth         int
+		Headings         []*Item
+		HiddenCounts     map[string]int
+		MoveItem         *Item
 	}{
-		Token:      getCSRFToken(w, r),
-		List:       list,
-		ShowDelete: r.URL.Query().Get("delete") != "",
+		Token:            s.getCSRFToken(w, r),
+		List:             list,
+		ShowDelete:       query.Get("delete") != "",
+		ShowBulk:         query.Get("bulk") != "",
+		ShowDeleteDone:   query.Get("delete-done") != "" && doneCount > 0,
+		ShowUncheckAll:   query.Get("uncheck-all") != "" && uncheckCount > 0,
+		ShowTemplate:     s.showLists,
+		EditTemplate:     query.Get("template") != "" && s.showLists,
+		DoneCount:        doneCount,
+		UncheckCount:     uncheckCount,
+		HasDue:           hasDue,
+		SortByDue:        sortByDue,
+		EditNotes:        findItem(list.Items, query.Get("notes")),
+		CompleteChildren: completeChildren,
+		UndoneChildren:   undoneChildren,
+		MaxDepth:         maxItemDepth,
+		Headings:         headings,
+		HiddenCounts:     hiddenCounts,
+	}
+	if query.Get("duplicate") != "" {
+		data.CopyName = list.Name + copySuffix
+	}
+	if item := findItem(list.Items, query.Get("due")); item != nil && !item.Done && !item.Heading {
+		data.EditDue = item
+		if !item.TimeDue.IsZero() {
+			due := item.TimeDue.In(s.location)
+			data.DueDate = due.Format("2006-01-02")
+			if !item.DueAllDay {
+				data.DueTime = due.Format("15:04")
+			}
+		}
+	}
+	if item := findItem(list.Items, query.Get("repeat")); item != nil && !item.Done {
+		data.EditRepeat = item
+		data.RepeatFrequency = strings.SplitN(item.Repeat, ":", 2)[0]
+		data.RepeatWeekdays = s.weekdayOptions(item)
+	}
+	if item := findItem(list.Items, query.Get("parent")); item != nil && !item.Done && !item.Heading &&
+		item.Depth < maxItemDepth {
+		data.AddUnder = item
+	}
+	if item := findItem(list.Items, query.Get("move")); item != nil && !item.Heading {
+		data.MoveItem = item
 	}
 	err = s.listTmpl.Execute(w, data)
 	if err != nil {
-		s.internalError(w, "rendering template", err)
+		s.internalError(w, r, "rendering template", err)
 		return
 	}
 }
@@ -257,32 +903,32 @@ func (s *Server) createList(w http.ResponseWriter, r *http.Request) {
 	name := strings.TrimSpace(r.FormValue("name"))
 	if name == "" {
 		// Empty list name, just reload home page
-		http.Redirect(w, r, "/", http.StatusFound)
+		s.redirect(w, r, "/")
 		return
 	}
 	listID, err := s.model.CreateList(name)
 	if err != nil {
-		s.internalError(w, "creating list", err)
+		s.internalError(w, r, "creating list", err)
 		return
 	}
-	http.Redirect(w, r, "/lists/"+listID, http.StatusFound)
+	s.redirect(w, r, "/lists/"+listID)
 }
 
 func (s *Server) deleteList(w http.ResponseWriter, r *http.Request) {
 	id := r.FormValue("list-id")
 	err := s.model.DeleteList(id)
 	if err != nil {
-		s.internalError(w, "deleting list", err)
+		s.internalError(w, r, "deleting list", err)
 		return
 	}
-	http.Redirect(w, r, "/", http.StatusFound)
+	s.redirect(w, r, "/")
 }
 
 func (s *Server) addItem(w http.ResponseWriter, r *http.Request) {
 	listID := r.FormValue("list-id")
 	list, err := s.model.GetList(listID)
 	if err != nil {
-		s.internalError(w, "fetching list", err)
+		s.internalError(w, r, "fetching list", err)
 		return
 	}
 	if list == nil {
@@ -292,106 +938,124 @@ func (s *Server) addItem(w http.ResponseWriter, r *http.Request) {
 	description := strings.TrimSpace(r.FormValue("description"))
 	if description == "" {
 		// Empty item description, just reload list
-		http.Redirect(w, r, "/lists/"+list.ID, http.StatusFound)
+		s.redirect(w, r, "/lists/"+list.ID)
+		return
+	}
+	if name, ok := parseHeading(description); ok {
+		_, err = s.model.AddHeading(list.ID, name)
+		if err != nil {
+			s.internalError(w, r, "adding heading", err)
+			return
+		}
+		s.redirect(w, r, "/lists/"+list.ID)
+		return
+	}
+	due, allDay, err := s.parseDue(r.FormValue("due-date"), r.FormValue("due-time"))
+	if err != nil {
+		s.errorPage(w, r, http.StatusBadRequest, "Invalid due date",
+			"The item wasn't added because the "+err.Error()+".")
 		return
 	}
-	_, err = s.model.AddItem(list.ID, description)
+	var parent *Item
+	if parentID := r.FormValue("parent-id"); parentID != "" {
+		parent = findItem(list.Items, parentID)
+		if parent == nil || parent.Heading {
+			http.NotFound(w, r)
+			return
+		}
+		if parent.Depth >= maxItemDepth {
+			s.errorPage(w, r, http.StatusBadRequest, "Nested too deeply",
+				"The item wasn't added because sub-items can only be nested "+
+					strconv.Itoa(maxItemDepth)+" levels deep.")
+			return
+		}
+	}
+	item := &Item{Description: description, TimeDue: due, DueAllDay: allDay}
+	if parent != nil {
+		item.ParentID = parent.ID
+	}
+	err = s.model.AddItems(list.ID, []*Item{item})
 	if err != nil {
-		s.internalError(w, "adding item", err)
+		s.internalError(w, r, "adding item", err)
 		return
 	}
-	http.Redirect(w, r, "/lists/"+list.ID, http.StatusFound)
+	s.redirect(w, r, "/lists/"+list.ID)
 }
 
 func (s *Server) updateDone(w http.ResponseWriter, r *http.Request) {
 	listID := r.FormValue("list-id")
 	itemID := r.FormValue("item-id")
 	done := r.FormValue("done") == "on"
-	err := s.model.UpdateDone(listID, itemID, done)
+	err := s.model.UpdateDone(listID, itemID, done, s.now().In(s.location))
 	if err != nil {
-		s.internalError(w, "updating done flag", err)
+		s.internalError(w, r, "updating done flag", err)
 		return
 	}
-	http.Redirect(w, r, "/lists/"+listID, http.StatusFound)
+	returnPath := r.FormValue("return")
+	switch {
+	case returnPath == "/today" || returnPath == "/upcoming" || strings.HasPrefix(returnPath, "/tags/"):
+		// Checked off from an agenda or tag page, so go back there
+		s.redirect(w, r, returnPath)
+	case done:
+		// If it has sub-items that aren't done, offer to complete them too
+		hasUndone, err := s.hasUndoneChildren(listID, itemID)
+		if err != nil {
+			s.internalError(w, r, "fetching list", err)
+			return
+		}
+		if hasUndone {
+			s.redirect(w, r, "/lists/"+listID+"?complete-children="+url.QueryEscape(itemID))
+			return
+		}
+		s.redirect(w, r, "/lists/"+listID)
+	default:
+		s.redirect(w, r, "/lists/"+listID)
+	}
 }
 
-func (s *Server) deleteItem(w http.ResponseWriter, r *http.Request) {
+func (s *Server) setRepeat(w http.ResponseWriter, r *http.Request) {
 	listID := r.FormValue("list-id")
 	itemID := r.FormValue("item-id")
-	err := s.model.DeleteItem(listID, itemID)
+	list, err := s.model.GetList(listID)
 	if err != nil {
-		s.internalError(w, "deleting item", err)
+		s.internalError(w, r, "fetching list", err)
 		return
 	}
-	http.Redirect(w, r, "/lists/"+listID, http.StatusFound)
-}
-
-func (s *Server) internalError(w http.ResponseWriter, msg string, err error) {
-	s.logger.Printf("error %s: %v", msg, err)
-	http.Error(w, "error "+msg, http.StatusInternalServerError)
-}
-
-// GeneratePasswordHash generates a bcrypt hash from the given password.
-func GeneratePasswordHash(password string) (string, error) {
-	b, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	return string(b), err
-}
-
-// CheckPasswordHash returns a non-nil error if the given password hash is not
-// a valid bcrypt hash.
-func CheckPasswordHash(passwordHash string) error {
-	err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte("x"))
-	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
-		return nil
+	var item *Item
+	if list != nil {
+		item = findItem(list.Items, itemID)
 	}
-	return err
-}
-
-// csrf wraps the given handler, ensuring that the HTTP method is POST and
-// that the CSRF token in the "csrf-token" cookie matches the token in the
-// "csrf-token" form field.
-func csrf(h http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != "POST" {
-			w.Header().Set("Allow", "POST")
-			http.Error(w, "405 method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		token := r.FormValue("csrf-token")
-		cookie, err := r.Cookie("csrf-token")
-		if err != nil || token != cookie.Value {
-			http.Error(w, "invalid CSRF token or cookie", http.StatusBadRequest)
-			return
-		}
-		h(w, r)
+	if item == nil {
+		http.NotFound(w, r)
+		return
 	}
-}
-
-// getCSRFToken returns the current session's CSRF token, generating a new one
-// and settings the "csrf-token" cookie if not present.
-func getCSRFToken(w http.ResponseWriter, r *http.Request) string {
-	cookie, err := r.Cookie("csrf-token")
-	if err == nil && cookie.Value != "" {
-		return cookie.Value
+	rule, err := parseRepeat(r.FormValue("repeat"), r.Form["weekday"], s.itemDate(item))
+	if err != nil {
+		s.errorPage(w, r, http.StatusBadRequest, "Invalid repeat",
+			"The item's repeat wasn't changed because of an "+err.Error()+".")
+		return
 	}
-	token := generateCSRFToken()
-	cookie = &http.Cookie{
-		Name:     "csrf-token",
-		Value:    token,
-		Path:     "/",
-		Secure:   r.URL.Scheme == "https",
-		HttpOnly: true,
-		SameSite: http.SameSiteStrictMode,
+	err = s.model.SetItemRepeat(listID, itemID, rule)
+	if err != nil {
+		s.internalError(w, r, "setting repeat", err)
+		return
 	}
-	http.SetCookie(w, cookie)
-	return token
+	s.redirect(w, r, "/lists/"+listID)
 }
 
-func generateCSRFToken() string {
-	b := make([]byte, 16)
-	_, err := rand.Read(b)
-	if err != nil { // should never fail
-		panic(err)
+func (s *Server) deleteItem(w http.ResponseWriter, r *http.Request) {
+	listID := r.FormValue("list-id")
+	itemID := r.FormValue("item-id")
+	err := s.model.DeleteItem(listID, itemID)
+	if err != nil {
+		s.internalError(w, r, "deleting item", err)
+		return
 	}
-	return hex.EncodeToString(b)
+	s.redirect(w, r, "/lists/"+listID)
+}
+
+func (s *Server) internalError(w http.ResponseWriter, r *http.Request, msg string, err error) {
+	requestID := getRequestInfo(r).id
+	s.logger.Log(LevelError, "error "+msg, "error", err, "request_id", requestID)
+	http.Error(w, "error "+msg+" (request ID "+requestID+")", http.StatusInternalServerError)
 }
@@ -26,7 +26,7 @@ func TestServer(t *testing.T) {
 	if err != nil {
 		t.Fatalf("creating model: %v", err)
 	}
-	server, err := NewServer(model, nullLogger{}, "Pacific/Auckland", "", "", true)
+	server, err := NewServer(model, nullLogger{}, Config{Timezone: "Pacific/Auckland", Lists: true})
 	if err != nil {
 		t.Fatalf("creating server: %v", err)
 	}
@@ -85,7 +85,7 @@ func TestServer(t *testing.T) {
 		recorder := serve(t, server, jar, "GET", "/", nil)
 
 		links := parseLinks(t, recorder.Body.String())
-		ensureInt(t, len(links), 5) // 2 links per list (view + delete), 1 link for "About"
+		ensureInt(t, len(links), 7) // 2 links per list (view + delete), "Today", "Upcoming", "About"
 		ensureString(t, links[0].Href, "/lists/"+listIDs[1])
 		ensureString(t, links[0].Text, "Another List")
 		ensureString(t, links[1].Href, "/lists/"+listIDs[1]+"?delete=1")
@@ -94,7 +94,9 @@ func TestServer(t *testing.T) {
 		ensureString(t, links[2].Text, "Shopping List")
 		ensureString(t, links[3].Href, "/lists/"+listIDs[0]+"?delete=1")
 		ensureString(t, links[3].Text, "✕")
-		ensureString(t, links[4].Text, "About")
+		ensureString(t, links[4].Href, "/today")
+		ensureString(t, links[5].Href, "/upcoming")
+		ensureString(t, links[6].Text, "About")
 	}
 
 	// Fetch list page in "delete" mode
@@ -126,12 +128,12 @@ func TestServer(t *testing.T) {
 		recorder := serve(t, server, jar, "GET", "/", nil)
 
 		links := parseLinks(t, recorder.Body.String())
-		ensureInt(t, len(links), 3) // 2 links per list (view + delete), 1 link for "About"
+		ensureInt(t, len(links), 5) // 2 links per list (view + delete), "Today", "Upcoming", "About"
 		ensureString(t, links[0].Href, "/lists/"+listIDs[0])
 		ensureString(t, links[0].Text, "Shopping List")
 		ensureString(t, links[1].Href, "/lists/"+listIDs[0]+"?delete=1")
 		ensureString(t, links[1].Text, "✕")
-		ensureString(t, links[2].Text, "About")
+		ensureString(t, links[4].Text, "About")
 	}
 
 	// Fetch empty list
@@ -242,6 +244,232 @@ func TestServer(t *testing.T) {
 	}
 }
 
+func TestMetrics(t *testing.T) {
+	server, _ := newTestServer(t, nullLogger{}, Config{Lists: true, MetricsToken: "s3cret"})
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("creating cookie jar: %v", err)
+	}
+
+	// Metrics require token
+	{
+		recorder := serve(t, server, jar, "GET", "/metrics", nil)
+		ensureCode(t, recorder, http.StatusUnauthorized)
+	}
+
+	// Make a few requests
+	var csrfToken string
+	{
+		recorder := serve(t, server, jar, "GET", "/", nil)
+		forms := parseForms(t, recorder.Body.String())
+		csrfToken = forms[0].Inputs["csrf-token"]
+
+		form := url.Values{}
+		form.Set("csrf-token", csrfToken)
+		form.Set("name", "Shopping List")
+		recorder = serve(t, server, jar, "POST", "/create-list", form)
+		ensureCode(t, recorder, http.StatusFound)
+
+		recorder = serve(t, server, jar, "GET", "/lists/nonexistent", nil)
+		ensureCode(t, recorder, http.StatusNotFound)
+	}
+
+	// Fetch metrics with token
+	{
+		r, err := http.NewRequest("GET", "http://localhost/metrics", nil)
+		if err != nil {
+			t.Fatalf("creating request: %v", err)
+		}
+		r.Header.Set("Authorization", "Bearer s3cret")
+		recorder := httptest.NewRecorder()
+		server.ServeHTTP(recorder, r)
+
+		ensureCode(t, recorder, http.StatusOK)
+		body := recorder.Body.String()
+		for _, line := range []string{
+			`simplelists_http_requests_total{route="/",status="200"} 1`,
+			`simplelists_http_requests_total{route="/create-list",status="302"} 1`,
+			`simplelists_http_requests_total{route="/lists/",status="404"} 1`,
+			`simplelists_http_requests_total{route="/metrics",status="401"} 1`,
+			`simplelists_http_request_duration_seconds_count{route="/create-list",status="302"} 1`,
+			`simplelists_model_call_duration_seconds_count{method="CreateList"} 1`,
+			`simplelists_failed_sign_ins_total 0`,
+			`simplelists_lists 1`,
+			`simplelists_items 0`,
+			`simplelists_active_sign_ins 0`,
+		} {
+			if !strings.Contains(body, "\n"+line+"\n") {
+				t.Errorf("metrics output doesn't contain %q", line)
+			}
+		}
+	}
+}
+
+func TestBasePath(t *testing.T) {
+	server, _ := newTestServer(t, nullLogger{}, Config{Lists: true, BasePath: "/lists-app/"})
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("creating cookie jar: %v", err)
+	}
+
+	// Paths outside the base path aren't found
+	{
+		recorder := serve(t, server, jar, "GET", "/", nil)
+		ensureCode(t, recorder, http.StatusNotFound)
+		recorder = serve(t, server, jar, "GET", "/lists-appx/", nil)
+		ensureCode(t, recorder, http.StatusNotFound)
+	}
+
+	// Base path without trailing slash redirects to homepage
+	{
+		recorder := serve(t, server, jar, "GET", "/lists-app", nil)
+		ensureRedirect(t, recorder, http.StatusFound, "/lists-app/")
+	}
+
+	// Fetch homepage
+	var csrfToken string
+	{
+		recorder := serve(t, server, jar, "GET", "/lists-app/", nil)
+
+		ensureCode(t, recorder, http.StatusOK)
+		forms := parseForms(t, recorder.Body.String())
+		ensureInt(t, len(forms), 1)
+		ensureString(t, forms[0].Action, "/lists-app/create-list")
+		csrfToken = forms[0].Inputs["csrf-token"]
+		cookies := recorder.Result().Cookies()
+		ensureInt(t, len(cookies), 1)
+		ensureString(t, cookies[0].Name, "csrf-id")
+		ensureString(t, cookies[0].Path, "/lists-app/")
+	}
+
+	// Create list and fetch it
+	{
+		form := url.Values{}
+		form.Set("csrf-token", csrfToken)
+		form.Set("name", "Shopping List")
+		recorder := serve(t, server, jar, "POST", "/lists-app/create-list", form)
+
+		ensureCode(t, recorder, http.StatusFound)
+		location := recorder.Result().Header.Get("Location")
+		ensureRegex(t, location, "/lists-app/lists/[a-z]{10}")
+
+		recorder = serve(t, server, jar, "GET", location, nil)
+		ensureCode(t, recorder, http.StatusOK)
+		forms := parseForms(t, recorder.Body.String())
+		ensureInt(t, len(forms), 1)
+		ensureString(t, forms[0].Action, "/lists-app/add-item")
+		links := parseLinks(t, recorder.Body.String())
+		ensureString(t, links[0].Href, location+"?bulk=1")
+		ensureString(t, links[1].Href, location+"?duplicate=1")
+		ensureString(t, links[2].Href, location+"?template=1")
+		ensureString(t, links[3].Href, "/lists-app/")
+	}
+}
+
+// newTestServer creates a server with an in-memory database.
+func newTestServer(t *testing.T, logger Logger, config Config) (*Server, *SQLModel) {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	model, err := NewSQLModel(db)
+	if err != nil {
+		t.Fatalf("creating model: %v", err)
+	}
+	server, err := NewServer(model, logger, config)
+	if err != nil {
+		t.Fatalf("creating server: %v", err)
+	}
+	return server, model
+}
+
+// testList is a list on a test server, along with a browser session (and
+// its CSRF token) for submitting the list's forms.
+type testList struct {
+	t      *testing.T
+	server *Server
+	model  *SQLModel
+	jar    http.CookieJar
+	token  string
+	id     string
+}
+
+// newTestList creates a test server with the given config and a list with
+// the given name, and fetches the list page to get a CSRF token.
+func newTestList(t *testing.T, config Config, name string) *testList {
+	t.Helper()
+	server, model := newTestServer(t, nullLogger{}, config)
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("creating cookie jar: %v", err)
+	}
+	id, err := model.CreateList(name)
+	if err != nil {
+		t.Fatalf("creating list: %v", err)
+	}
+	recorder := serve(t, server, jar, "GET", "/lists/"+id, nil)
+	token := parseForms(t, recorder.Body.String())[0].Inputs["csrf-token"]
+	return &testList{t: t, server: server, model: model, jar: jar, token: token, id: id}
+}
+
+// get fetches the given path in the list's browser session.
+func (l *testList) get(path string) *httptest.ResponseRecorder {
+	l.t.Helper()
+	return serve(l.t, l.server, l.jar, "GET", path, nil)
+}
+
+// post submits a form with the CSRF token, and with the list's ID unless
+// the form already has a list-id.
+func (l *testList) post(path string, form url.Values) *httptest.ResponseRecorder {
+	l.t.Helper()
+	form.Set("csrf-token", l.token)
+	if form.Get("list-id") == "" {
+		form.Set("list-id", l.id)
+	}
+	return serve(l.t, l.server, l.jar, "POST", path, form)
+}
+
+// addItem adds an item (or "## heading") to the list using the add-item
+// form, as a sub-item of parentID if it's not "".
+func (l *testList) addItem(description, parentID string) {
+	l.t.Helper()
+	form := url.Values{}
+	form.Set("description", description)
+	form.Set("parent-id", parentID)
+	recorder := l.post("/add-item", form)
+	ensureRedirect(l.t, recorder, http.StatusFound, "/lists/"+l.id)
+}
+
+// outline returns the list's items in order, one per line and indented by
+// depth, like "[x] Build" or "## Produce" for a heading.
+func (l *testList) outline() string {
+	l.t.Helper()
+	return listOutline(l.t, l.model, l.id)
+}
+
+// listOutline returns the outline of the given list (see testList.outline).
+func listOutline(t *testing.T, model *SQLModel, listID string) string {
+	t.Helper()
+	list, err := model.GetList(listID)
+	if err != nil {
+		t.Fatalf("fetching list: %v", err)
+	}
+	var lines []string
+	for _, item := range list.Items {
+		line := "[ ] " + item.Description
+		switch {
+		case item.Heading:
+			line = "## " + item.Description
+		case item.Done:
+			line = "[x] " + item.Description
+		}
+		lines = append(lines, strings.Repeat("  ", item.Depth)+line)
+	}
+	return strings.Join(lines, "\n")
+}
+
 // ensureCode asserts that the HTTP status code is correct.
 func ensureCode(t *testing.T, recorder *httptest.ResponseRecorder, expected int) {
 	t.Helper()
@@ -297,6 +525,7 @@ func serve(t *testing.T, server *Server, jar http.CookieJar, method, path string
 	}
 	if form != nil {
 		r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("Origin", "http://localhost") // as browsers do for form posts
 	}
 	for _, c := range jar.Cookies(r.URL) {
 		r.Header.Add("Cookie", c.Name+"="+c.Value)
@@ -422,4 +651,4 @@ func getText(n *html.Node) string {
 
 type nullLogger struct{}
 
-func (nullLogger) Printf(format string, v ...interface{}) {}
+func (nullLogger) Log(level LogLevel, msg string, keyvals ...interface{}) {}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+)
+
+func (s *Server) showSessions(w http.ResponseWriter, r *http.Request) {
+	if !s.signInRequired() {
+		// No sign-ins if authentication isn't enabled
+		http.NotFound(w, r)
+		return
+	}
+	createdAfter, lastSeenAfter := s.signInCutoffs()
+	signIns, err := s.model.GetSignIns(getSignInCookie(r), createdAfter, lastSeenAfter)
+	if err != nil {
+		s.internalError(w, r, "fetching sign ins", err)
+		return
+	}
+	for _, signIn := range signIns {
+		// Change UTC timezone to display timezone
+		signIn.TimeCreated = signIn.TimeCreated.In(s.location)
+		signIn.TimeLastSeen = signIn.TimeLastSeen.In(s.location)
+	}
+
+	var data = struct {
+		Token   string
+		SignIns []*SignIn
+	}{
+		Token:   s.getCSRFToken(w, r),
+		SignIns: signIns,
+	}
+	err = s.sessionsTmpl.Execute(w, data)
+	if err != nil {
+		s.internalError(w, r, "rendering template", err)
+		return
+	}
+}
+
+func (s *Server) revokeSession(w http.ResponseWriter, r *http.Request) {
+	key := r.FormValue("key")
+	err := s.model.DeleteSignInByKey(getSignInCookie(r), key)
+	if err != nil {
+		s.internalError(w, r, "deleting sign in", err)
+		return
+	}
+	s.redirect(w, r, "/sessions")
+}
+
+func (s *Server) signOutEverywhere(w http.ResponseWriter, r *http.Request) {
+	cookie := &http.Cookie{
+		Name:     "sign-in",
+		MaxAge:   -1,
+		Path:     s.cookiePath(),
+		Secure:   r.URL.Scheme == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}
+	http.SetCookie(w, cookie)
+
+	// Only the current user's sign-ins are deleted (with OpenID Connect
+	// there may be several users)
+	err := s.model.DeleteAllSignIns(getSignInCookie(r))
+	if err != nil {
+		s.internalError(w, r, "deleting sign ins", err)
+		return
+	}
+
+	s.redirect(w, r, "/")
+}
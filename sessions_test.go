@@ -0,0 +1,291 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessions(t *testing.T) {
+	hash, err := GeneratePasswordHash("password")
+	if err != nil {
+		t.Fatalf("generating password hash: %v", err)
+	}
+	server, _ := newTestServer(t, nullLogger{}, Config{Username: "bob", PassHash: hash})
+
+	// Sign in from two browsers
+	var jars []http.CookieJar
+	var tokens []string
+	for i := 0; i < 2; i++ {
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			t.Fatalf("creating cookie jar: %v", err)
+		}
+		recorder := serve(t, server, jar, "GET", "/", nil)
+		forms := parseForms(t, recorder.Body.String())
+		token := forms[0].Inputs["csrf-token"]
+		form := url.Values{}
+		form.Set("csrf-token", token)
+		form.Set("username", "bob")
+		form.Set("password", "password")
+		recorder = serve(t, server, jar, "POST", "/sign-in", form)
+		ensureRedirect(t, recorder, http.StatusFound, "/")
+
+		// CSRF token changes on sign in
+		recorder = serve(t, server, jar, "GET", "/", nil)
+		forms = parseForms(t, recorder.Body.String())
+		if forms[0].Inputs["csrf-token"] == token {
+			t.Fatalf("CSRF token not rotated on sign in")
+		}
+		jars = append(jars, jar)
+		tokens = append(tokens, forms[0].Inputs["csrf-token"])
+	}
+
+	// Sessions page shows both, with a revoke button for the other one
+	var otherKey string
+	{
+		recorder := serve(t, server, jars[0], "GET", "/sessions", nil)
+		ensureCode(t, recorder, http.StatusOK)
+		forms := parseForms(t, recorder.Body.String())
+		ensureInt(t, len(forms), 2) // 1 per other session, 1 for "sign out everywhere"
+		ensureString(t, forms[0].Action, "/revoke-session")
+		ensureString(t, forms[1].Action, "/sign-out-everywhere")
+		otherKey = forms[0].Inputs["key"]
+		if otherKey == "" {
+			t.Fatalf("session key not found")
+		}
+		links := parseLinks(t, recorder.Body.String())
+		ensureString(t, links[0].Text, "Home")
+	}
+
+	// Revoke other session
+	{
+		form := url.Values{}
+		form.Set("csrf-token", tokens[0])
+		form.Set("key", otherKey)
+		recorder := serve(t, server, jars[0], "POST", "/revoke-session", form)
+		ensureRedirect(t, recorder, http.StatusFound, "/sessions")
+
+		recorder = serve(t, server, jars[1], "GET", "/sessions", nil)
+		ensureRedirect(t, recorder, http.StatusFound, "/?return-url=%2Fsessions")
+
+		recorder = serve(t, server, jars[0], "GET", "/sessions", nil)
+		forms := parseForms(t, recorder.Body.String())
+		ensureInt(t, len(forms), 1)
+	}
+
+	// Sign out everywhere
+	{
+		form := url.Values{}
+		form.Set("csrf-token", tokens[0])
+		recorder := serve(t, server, jars[0], "POST", "/sign-out-everywhere", form)
+		ensureRedirect(t, recorder, http.StatusFound, "/")
+
+		recorder = serve(t, server, jars[0], "GET", "/sessions", nil)
+		ensureRedirect(t, recorder, http.StatusFound, "/?return-url=%2Fsessions")
+	}
+}
+
+func TestSessionsPerUser(t *testing.T) {
+	server, model := newTestServer(t, nullLogger{}, Config{
+		OIDCIssuer:         "https://issuer.example.com",
+		OIDCClientID:       "simplelists",
+		OIDCClientSecret:   "s3cret",
+		OIDCRedirectURL:    "http://localhost/oidc-callback",
+		OIDCAllowedDomains: "*",
+	})
+
+	// Two sessions for one OIDC subject, one for another (signed in directly,
+	// as the OIDC flow itself is tested elsewhere)
+	signIn := func(subject, userAgent string) (http.CookieJar, string) {
+		t.Helper()
+		id, err := model.CreateSignIn(subject, userAgent, "127.0.0.1", time.Now())
+		if err != nil {
+			t.Fatalf("creating sign in: %v", err)
+		}
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			t.Fatalf("creating cookie jar: %v", err)
+		}
+		jar.SetCookies(&url.URL{Scheme: "http", Host: "localhost", Path: "/"},
+			[]*http.Cookie{{Name: "sign-in", Value: id}})
+		recorder := serve(t, server, jar, "GET", "/", nil)
+		return jar, parseForms(t, recorder.Body.String())[0].Inputs["csrf-token"]
+	}
+	aliceJar, aliceToken := signIn("alice", "Alice Laptop")
+	alicePhone, _ := signIn("alice", "Alice Phone")
+	carolJar, carolToken := signIn("carol", "Carol Laptop")
+
+	// Each user only sees their own sessions
+	recorder := serve(t, server, carolJar, "GET", "/sessions", nil)
+	body := recorder.Body.String()
+	if strings.Contains(body, "Alice") || !strings.Contains(body, "Carol Laptop") {
+		t.Fatalf("unexpected sessions:\n%s", body)
+	}
+	recorder = serve(t, server, aliceJar, "GET", "/sessions", nil)
+	forms := parseForms(t, recorder.Body.String())
+	ensureInt(t, len(forms), 2)
+	aliceKey := forms[0].Inputs["key"]
+
+	// And can't revoke another user's sessions
+	form := url.Values{}
+	form.Set("csrf-token", carolToken)
+	form.Set("key", aliceKey)
+	recorder = serve(t, server, carolJar, "POST", "/revoke-session", form)
+	ensureRedirect(t, recorder, http.StatusFound, "/sessions")
+	recorder = serve(t, server, alicePhone, "GET", "/sessions", nil)
+	ensureCode(t, recorder, http.StatusOK)
+
+	// Signing out everywhere only signs out that user
+	form = url.Values{}
+	form.Set("csrf-token", aliceToken)
+	recorder = serve(t, server, aliceJar, "POST", "/sign-out-everywhere", form)
+	ensureRedirect(t, recorder, http.StatusFound, "/")
+	recorder = serve(t, server, alicePhone, "GET", "/sessions", nil)
+	ensureRedirect(t, recorder, http.StatusFound, "/?return-url=%2Fsessions")
+	recorder = serve(t, server, carolJar, "GET", "/sessions", nil)
+	ensureCode(t, recorder, http.StatusOK)
+}
+
+func TestMigrateSignIns(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`
+		CREATE TABLE sign_ins (
+			id VARCHAR(64) NOT NULL PRIMARY KEY,
+			time_created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+		INSERT INTO sign_ins (id) VALUES ('old');
+		`)
+	if err != nil {
+		t.Fatalf("creating old schema: %v", err)
+	}
+
+	model, err := NewSQLModel(db)
+	if err != nil {
+		t.Fatalf("creating model: %v", err)
+	}
+	signIns, err := model.GetSignIns("old", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("fetching sign ins: %v", err)
+	}
+	ensureInt(t, len(signIns), 1)
+	if !signIns[0].Current || signIns[0].TimeLastSeen != signIns[0].TimeCreated {
+		t.Fatalf("unexpected sign in: %+v", signIns[0])
+	}
+
+	// Raw sign-in IDs are replaced by their hashes, only once
+	var storedID string
+	err = db.QueryRow("SELECT id FROM sign_ins").Scan(&storedID)
+	if err != nil {
+		t.Fatalf("fetching stored ID: %v", err)
+	}
+	ensureString(t, storedID, hashSignInID("old"))
+	_, err = NewSQLModel(db)
+	if err != nil {
+		t.Fatalf("creating model again: %v", err)
+	}
+	signIn, err := model.GetSignIn("old")
+	if err != nil || signIn == nil {
+		t.Fatalf("fetching sign in: %v %v", signIn, err)
+	}
+
+	// New sign-ins are stored hashed
+	id, err := model.CreateSignIn("bob", "Browser", "127.0.0.1", time.Now())
+	if err != nil {
+		t.Fatalf("creating sign in: %v", err)
+	}
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM sign_ins WHERE id = ?", hashSignInID(id)).Scan(&count)
+	if err != nil {
+		t.Fatalf("counting sign ins: %v", err)
+	}
+	ensureInt(t, count, 1)
+}
+
+func TestSessionExpiry(t *testing.T) {
+	hash, err := GeneratePasswordHash("password")
+	if err != nil {
+		t.Fatalf("generating password hash: %v", err)
+	}
+	server, _ := newTestServer(t, nullLogger{}, Config{
+		Username:             "bob",
+		PassHash:             hash,
+		SessionLifetime:      "2d",
+		SessionIdleTimeout:   "1h",
+		SessionRenewInterval: "5m",
+	})
+	now := time.Date(2021, 9, 10, 12, 0, 0, 0, time.UTC)
+	server.now = func() time.Time { return now }
+
+	signIn := func() http.CookieJar {
+		t.Helper()
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			t.Fatalf("creating cookie jar: %v", err)
+		}
+		recorder := serve(t, server, jar, "GET", "/", nil)
+		forms := parseForms(t, recorder.Body.String())
+		form := url.Values{}
+		form.Set("csrf-token", forms[0].Inputs["csrf-token"])
+		form.Set("username", "bob")
+		form.Set("password", "password")
+		recorder = serve(t, server, jar, "POST", "/sign-in", form)
+		ensureRedirect(t, recorder, http.StatusFound, "/")
+		ensureInt(t, signInCookieMaxAge(recorder), 60*60)
+		return jar
+	}
+
+	// Idle timeout slides each time the session is used, but the last-used
+	// time (and cookie) is only renewed once per renew interval
+	jar := signIn()
+	now = now.Add(time.Minute)
+	recorder := serve(t, server, jar, "GET", "/sessions", nil)
+	ensureCode(t, recorder, http.StatusOK)
+	ensureInt(t, signInCookieMaxAge(recorder), -1)
+	now = now.Add(50 * time.Minute)
+	recorder = serve(t, server, jar, "GET", "/sessions", nil)
+	ensureCode(t, recorder, http.StatusOK)
+	ensureInt(t, signInCookieMaxAge(recorder), 60*60)
+	now = now.Add(50 * time.Minute)
+	recorder = serve(t, server, jar, "GET", "/sessions", nil)
+	ensureCode(t, recorder, http.StatusOK)
+
+	// Session expires after idle timeout
+	now = now.Add(61 * time.Minute)
+	recorder = serve(t, server, jar, "GET", "/sessions", nil)
+	ensureRedirect(t, recorder, http.StatusFound, "/?return-url=%2Fsessions")
+
+	// Session expires after absolute lifetime even if it's in constant use,
+	// and the cookie expires at the same time
+	start := now
+	jar = signIn()
+	for now.Before(start.Add(47*time.Hour + 30*time.Minute)) {
+		now = now.Add(30 * time.Minute)
+		recorder = serve(t, server, jar, "GET", "/sessions", nil)
+		ensureCode(t, recorder, http.StatusOK)
+	}
+	ensureInt(t, signInCookieMaxAge(recorder), 30*60)
+	now = start.Add(48*time.Hour + time.Second)
+	recorder = serve(t, server, jar, "GET", "/sessions", nil)
+	ensureRedirect(t, recorder, http.StatusFound, "/?return-url=%2Fsessions")
+}
+
+// signInCookieMaxAge returns the MaxAge of the sign-in cookie set in the
+// response, or -1 if it wasn't set.
+func signInCookieMaxAge(recorder *httptest.ResponseRecorder) int {
+	for _, cookie := range recorder.Result().Cookies() {
+		if cookie.Name == "sign-in" {
+			return cookie.MaxAge
+		}
+	}
+	return -1
+}
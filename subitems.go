@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+)
+
+// maxItemDepth is the deepest nesting level allowed for sub-items (top-level
+// items are level 0).
+const maxItemDepth = 5
+
+// findItem returns the item with the given ID, or nil if it's not found.
+func findItem(items []*Item, id string) *Item {
+	if id == "" {
+		return nil
+	}
+	for _, item := range items {
+		if item.ID == id {
+			return item
+		}
+	}
+	return nil
+}
+
+// descendants returns the children of the given item, their children, and
+// so on. The items must be in nested order (as returned by GetList).
+func descendants(items []*Item, parent *Item) []*Item {
+	for i, item := range items {
+		if item != parent {
+			continue
+		}
+		end := i + 1
+		for end < len(items) && items[end].Depth > parent.Depth {
+			end++
+		}
+		return items[i+1 : end]
+	}
+	return nil
+}
+
+// hasUndoneChildren reports whether any of the given item's descendants
+// aren't done.
+func (s *Server) hasUndoneChildren(listID, itemID string) (bool, error) {
+	list, err := s.model.GetList(listID)
+	if err != nil || list == nil {
+		return false, err
+	}
+	item := findItem(list.Items, itemID)
+	if item == nil {
+		return false, nil
+	}
+	for _, child := range descendants(list.Items, item) {
+		if !child.Done {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *Server) completeChildren(w http.ResponseWriter, r *http.Request) {
+	listID := r.FormValue("list-id")
+	itemID := r.FormValue("item-id")
+	err := s.model.UpdateChildrenDone(listID, itemID, true)
+	if err != nil {
+		s.internalError(w, r, "updating done flags", err)
+		return
+	}
+	s.redirect(w, r, "/lists/"+listID)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSubItems(t *testing.T) {
+	l := newTestList(t, Config{}, "Release")
+	l.addItem("Build", "")
+	l.addItem("Test", "")
+	l.addItem("Compile", "1")
+	l.addItem("Unit tests", "2")
+	l.addItem("Link", "1")
+	l.addItem("Strip", "5")
+	l.addItem("Deploy", "")
+	ensureString(t, l.outline(), strings.Join([]string{
+		"[ ] Build",
+		"  [ ] Compile",
+		"  [ ] Link",
+		"    [ ] Strip",
+		"[ ] Test",
+		"  [ ] Unit tests",
+		"[ ] Deploy",
+	}, "\n"))
+
+	// Sub-item form and indentation
+	recorder := l.get("/lists/" + l.id + "?parent=5")
+	forms := parseForms(t, recorder.Body.String())
+	ensureString(t, forms[0].Action, "/add-item")
+	ensureString(t, forms[0].Inputs["parent-id"], "5")
+	ensureString(t, forms[0].Label, "Add sub-item under “Link”")
+	if !strings.Contains(recorder.Body.String(), `<li class="depth-2">`) {
+		t.Fatalf("sub-items not indented:\n%s", recorder.Body.String())
+	}
+
+	// Parent must be in the list, and nesting is limited
+	form := url.Values{}
+	form.Set("description", "Elsewhere")
+	form.Set("parent-id", "999")
+	recorder = l.post("/add-item", form)
+	ensureCode(t, recorder, http.StatusNotFound)
+	parentID := "6"
+	for depth := 3; depth <= maxItemDepth; depth++ {
+		l.addItem("Level "+strconv.Itoa(depth), parentID)
+		parentID = strconv.Itoa(5 + depth)
+	}
+	form.Set("parent-id", parentID)
+	recorder = l.post("/add-item", form)
+	ensureCode(t, recorder, http.StatusBadRequest)
+
+	// Completing a parent offers to complete its children
+	form = url.Values{}
+	form.Set("item-id", "2")
+	form.Set("done", "on")
+	recorder = l.post("/update-done", form)
+	ensureRedirect(t, recorder, http.StatusFound, "/lists/"+l.id+"?complete-children=2")
+	recorder = l.get("/lists/" + l.id + "?complete-children=2")
+	forms = parseForms(t, recorder.Body.String())
+	ensureString(t, forms[0].Action, "/complete-children")
+	ensureString(t, forms[0].Inputs["item-id"], "2")
+	if !strings.Contains(recorder.Body.String(), "still has 1 sub-item to do. Mark it done too?") {
+		t.Fatalf("complete children not offered:\n%s", recorder.Body.String())
+	}
+	form = url.Values{}
+	form.Set("item-id", "2")
+	recorder = l.post("/complete-children", form)
+	ensureRedirect(t, recorder, http.StatusFound, "/lists/"+l.id)
+
+	// No offer if there are no children to do
+	form = url.Values{}
+	form.Set("item-id", "7")
+	form.Set("done", "on")
+	recorder = l.post("/update-done", form)
+	ensureRedirect(t, recorder, http.StatusFound, "/lists/"+l.id)
+
+	// Deleting a parent deletes its subtree
+	form = url.Values{}
+	form.Set("item-id", "5")
+	recorder = l.post("/delete-item", form)
+	ensureCode(t, recorder, http.StatusFound)
+	ensureString(t, l.outline(), strings.Join([]string{
+		"[ ] Build",
+		"  [ ] Compile",
+		"[x] Test",
+		"  [x] Unit tests",
+		"[x] Deploy",
+	}, "\n"))
+}
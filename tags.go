@@ -0,0 +1,99 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// maxTagLength is the maximum length in bytes of a tag name; longer tags
+// are truncated.
+const maxTagLength = 64
+
+// tagRegex matches a #tag at the start of the text or after a space. Tags
+// are made of letters, digits, underscores, and hyphens.
+var tagRegex = regexp.MustCompile(`(^|\s)#([\pL\pN_-]+)`)
+
+// parseTags returns the normalized (lowercase, unique) tags in an item
+// description, for example "#Urgent" is the tag "urgent".
+func parseTags(description string) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, match := range tagRegex.FindAllStringSubmatch(description, -1) {
+		tag := normalizeTag(match[2])
+		if !seen[tag] {
+			tags = append(tags, tag)
+			seen[tag] = true
+		}
+	}
+	return tags
+}
+
+func normalizeTag(tag string) string {
+	tag = strings.ToLower(tag)
+	if len(tag) > maxTagLength {
+		tag = strings.ToValidUTF8(tag[:maxTagLength], "")
+	}
+	return tag
+}
+
+// tagLinks returns an item description as HTML, with its #tags linked to
+// their tag pages. Everything else is escaped, so it's safe to output as-is.
+// Tag pages aren't available unless lists are shown, so then the tags are
+// plain text.
+func (s *Server) tagLinks(description string) template.HTML {
+	if !s.showLists {
+		return template.HTML(template.HTMLEscapeString(description))
+	}
+	var b strings.Builder
+	last := 0
+	for _, match := range tagRegex.FindAllStringSubmatchIndex(description, -1) {
+		start, end := match[4]-1, match[5] // include the "#"
+		b.WriteString(template.HTMLEscapeString(description[last:start]))
+		href := s.basePath + "/tags/" + url.PathEscape(normalizeTag(description[match[4]:end]))
+		b.WriteString(`<a class="tag" href="` + template.HTMLEscapeString(href) + `">` +
+			template.HTMLEscapeString(description[start:end]) + `</a>`)
+		last = end
+	}
+	b.WriteString(template.HTMLEscapeString(description[last:]))
+	return template.HTML(b.String())
+}
+
+func (s *Server) showTag(w http.ResponseWriter, r *http.Request) {
+	tag := normalizeTag(r.URL.Path[len("/tags/"):])
+	if !s.showLists || tag == "" {
+		// Like the agenda, only available if the home page shows the lists
+		http.NotFound(w, r)
+		return
+	}
+	items, err := s.model.GetTagItems(tag)
+	if err != nil {
+		s.internalError(w, r, "fetching tagged items", err)
+		return
+	}
+	var groups []*agendaGroup
+	if len(items) > 0 {
+		for _, item := range items {
+			if !item.TimeDue.IsZero() {
+				// Change UTC timezone to display timezone
+				item.TimeDue = item.TimeDue.In(s.location)
+			}
+		}
+		groups = append(groups, &agendaGroup{Items: items})
+	}
+
+	page := &agendaPage{
+		Token:  s.getCSRFToken(w, r),
+		Title:  "#" + tag,
+		Return: "/tags/" + url.PathEscape(tag),
+		Empty:  "No items to do are tagged #" + tag + ".",
+		Groups: groups,
+	}
+	err = s.agendaTmpl.Execute(w, page)
+	if err != nil {
+		s.internalError(w, r, "rendering template", err)
+		return
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		description string
+		want        string
+	}{
+		{"no tags", ""},
+		{"#Urgent fix #hardware-store run", "urgent,hardware-store"},
+		{"dup #a #A #a_b", "a,a_b"},
+		{"not a#tag or #, but #café is", "café"},
+		{"#" + strings.Repeat("x", 70), strings.Repeat("x", maxTagLength)},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			ensureString(t, strings.Join(parseTags(test.description), ","), test.want)
+		})
+	}
+}
+
+func TestTags(t *testing.T) {
+	server, model := newTestServer(t, nullLogger{}, Config{Lists: true})
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("creating cookie jar: %v", err)
+	}
+	shopping, err := model.CreateList("Shopping")
+	if err != nil {
+		t.Fatalf("creating list: %v", err)
+	}
+	house, err := model.CreateList("House")
+	if err != nil {
+		t.Fatalf("creating list: %v", err)
+	}
+	recorder := serve(t, server, jar, "GET", "/lists/"+shopping, nil)
+	token := parseForms(t, recorder.Body.String())[0].Inputs["csrf-token"]
+	addItem := func(listID, description string) {
+		t.Helper()
+		form := url.Values{}
+		form.Set("csrf-token", token)
+		form.Set("list-id", listID)
+		form.Set("description", description)
+		recorder := serve(t, server, jar, "POST", "/add-item", form)
+		ensureRedirect(t, recorder, http.StatusFound, "/lists/"+listID)
+	}
+	addItem(shopping, "Screws #hardware-store")
+	addItem(shopping, "Milk")
+	addItem(house, "Fix <door> #Urgent #hardware-store")
+	addItem(house, "Paint #hardware-store")
+	list, err := model.GetList(house)
+	if err != nil {
+		t.Fatalf("fetching list: %v", err)
+	}
+	err = model.UpdateDone(house, list.Items[1].ID, true, server.now())
+	if err != nil {
+		t.Fatalf("updating done: %v", err)
+	}
+
+	// Tags are linked (and the rest escaped)
+	recorder = serve(t, server, jar, "GET", "/lists/"+house, nil)
+	want := `<label for="done-3">Fix &lt;door&gt; <a class="tag" href="/tags/urgent">#Urgent</a> <a class="tag" href="/tags/hardware-store">#hardware-store</a></label>`
+	if !strings.Contains(recorder.Body.String(), want) {
+		t.Fatalf("tags not linked:\n%s", recorder.Body.String())
+	}
+
+	// Tag page shows undone items across all lists
+	recorder = serve(t, server, jar, "GET", "/tags/hardware-store", nil)
+	ensureCode(t, recorder, http.StatusOK)
+	body := recorder.Body.String()
+	fix, screws := strings.Index(body, ">Fix &lt;door&gt; <a"), strings.Index(body, ">Screws <a")
+	if fix < 0 || screws < fix || strings.Contains(body, "Paint") || strings.Contains(body, "Milk") {
+		t.Fatalf("expected Fix and Screws items only:\n%s", body)
+	}
+	forms := parseForms(t, recorder.Body.String())
+	ensureString(t, forms[0].Inputs["return"], "/tags/hardware-store")
+	form := url.Values{}
+	for name, value := range forms[0].Inputs {
+		form.Set(name, value)
+	}
+	recorder = serve(t, server, jar, "POST", "/update-done", form)
+	ensureRedirect(t, recorder, http.StatusFound, "/tags/hardware-store")
+
+	recorder = serve(t, server, jar, "GET", "/tags/Urgent", nil)
+	ensureCode(t, recorder, http.StatusOK)
+	if !strings.Contains(recorder.Body.String(), "No items to do are tagged #urgent.") {
+		t.Fatalf("expected no items:\n%s", recorder.Body.String())
+	}
+
+	// Without lists shown (the default), tag pages aren't found, so tags
+	// aren't linked
+	hidden, hiddenModel := newTestServer(t, nullLogger{}, Config{})
+	recorder = serve(t, hidden, jar, "GET", "/tags/urgent", nil)
+	ensureCode(t, recorder, http.StatusNotFound)
+	listID, err := hiddenModel.CreateList("House")
+	if err != nil {
+		t.Fatalf("creating list: %v", err)
+	}
+	err = hiddenModel.AddItems(listID, []*Item{{Description: "Fix <door> #Urgent"}})
+	if err != nil {
+		t.Fatalf("adding items: %v", err)
+	}
+	recorder = serve(t, hidden, jar, "GET", "/lists/"+listID, nil)
+	want = `<label for="done-1">Fix &lt;door&gt; #Urgent</label>`
+	if !strings.Contains(recorder.Body.String(), want) {
+		t.Fatalf("tags linked:\n%s", recorder.Body.String())
+	}
+}
+
+func TestMigrateTags(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`
+		CREATE TABLE lists (
+			id VARCHAR(10) NOT NULL PRIMARY KEY,
+			time_created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			name VARCHAR(255) NOT NULL,
+			time_deleted TIMESTAMP
+		);
+		CREATE TABLE items (
+			id INTEGER NOT NULL PRIMARY KEY,
+			list_id INTEGER NOT NULL REFERENCES lists(id),
+			time_created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			description VARCHAR(255) NOT NULL,
+			done BOOLEAN NOT NULL DEFAULT FALSE,
+			time_deleted TIMESTAMP
+		);
+		INSERT INTO lists (id, name) VALUES ('abc', 'Old');
+		INSERT INTO items (list_id, description) VALUES ('abc', 'Old #tag'), ('abc', 'Untagged');
+		`)
+	if err != nil {
+		t.Fatalf("creating old schema: %v", err)
+	}
+
+	model, err := NewSQLModel(db)
+	if err != nil {
+		t.Fatalf("creating model: %v", err)
+	}
+	items, err := model.GetTagItems("tag")
+	if err != nil {
+		t.Fatalf("fetching tagged items: %v", err)
+	}
+	ensureInt(t, len(items), 1)
+	ensureString(t, items[0].Description, "Old #tag")
+	ensureString(t, items[0].ListName, "Old")
+}
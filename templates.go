@@ -4,47 +4,97 @@ var homeTmpl = `<!DOCTYPE html>
 <html>
  <head>
   <meta name="viewport" content="width=device-width, initial-scale=1">
+  <link rel="stylesheet" href="{{ url "/static/style.css" }}">
   <title>Simple Lists</title>
  </head>
  <body>
   <h1>Simple Lists</h1>
 {{ if .ShowSignOut }}
-  <form style="margin: 1em 0" action="/sign-out" method="POST" enctype="application/x-www-form-urlencoded">
+  <form class="block" action="{{ url "/sign-out" }}" method="POST" enctype="application/x-www-form-urlencoded">
    <input type="hidden" name="csrf-token" value="{{ $.Token }}">
    <button>Sign Out</button>
+   <a class="aside" href="{{ url "/sessions" }}">Sessions</a>
   </form>
 {{ end }}
-{{ if .ShowSignIn }}
-  <form style="margin: 1em 0" action="/sign-in" method="POST" enctype="application/x-www-form-urlencoded">
+{{ if .ShowTOTP }}
+  <form class="block" action="{{ url "/sign-in-totp" }}" method="POST" enctype="application/x-www-form-urlencoded">
+   <input type="hidden" name="csrf-token" value="{{ $.Token }}">
+   <input type="hidden" name="return-url" value="{{ .ReturnURL }}">
+   <input type="text" name="code" placeholder="authentication code" autocomplete="one-time-code" autofocus>
+   <button>Verify</button>
+   <div class="hint">enter the code from your authenticator app, or a recovery code</div>
+   {{ if .TOTPError }}
+   <div class="error">incorrect authentication code</div>
+   {{ end }}
+   {{ if .SignInLocked }}
+   <div class="error">too many failed attempts, please try again later</div>
+   {{ end }}
+  </form>
+{{ else if .ShowSignIn }}
+ {{ if .ShowPassword }}
+  <form class="block" action="{{ url "/sign-in" }}" method="POST" enctype="application/x-www-form-urlencoded">
    <input type="hidden" name="csrf-token" value="{{ $.Token }}">
    <input type="hidden" name="return-url" value="{{ .ReturnURL }}">
    <input type="text" name="username" placeholder="username" autofocus>
    <input type="password" name="password" placeholder="password" autofocus>
    <button>Sign In</button>
    {{ if .SignInError }}
-   <div style="color: red; margin: 0.5em 0;">incorrect username or password</div>
+   <div class="error">incorrect username or password</div>
+   {{ end }}
+   {{ if .SignInLocked }}
+   <div class="error">too many failed attempts, please try again later</div>
    {{ end }}
   </form>
+ {{ end }}
+ {{ if .ShowOIDC }}
+  <form class="block" action="{{ url "/sign-in-oidc" }}" method="POST" enctype="application/x-www-form-urlencoded">
+   <input type="hidden" name="csrf-token" value="{{ $.Token }}">
+   <input type="hidden" name="return-url" value="{{ .ReturnURL }}">
+   <button>Sign In with Single Sign-On</button>
+   {{ if .OIDCError }}
+   <div class="error">single sign-on failed</div>
+   {{ end }}
+  </form>
+ {{ end }}
 {{ else }}
-  <ul style="list-style-type: none; margin: 0; padding: 0;">
-   <li style="margin: 1em 0">
-    <form action="/create-list" method="POST" enctype="application/x-www-form-urlencoded">
+  <ul class="items">
+   <li class="spaced">
+    <form action="{{ url "/create-list" }}" method="POST" enctype="application/x-www-form-urlencoded">
      <input type="hidden" name="csrf-token" value="{{ $.Token }}">
      <input type="text" name="name" placeholder="list name" autofocus>
      <button>New List</button>
     </form>
    </li>
+   {{ if .Templates }}
+   <li class="spaced">
+    <form action="{{ url "/duplicate-list" }}" method="POST" enctype="application/x-www-form-urlencoded">
+     <input type="hidden" name="csrf-token" value="{{ $.Token }}">
+     <select name="list-id" title="Template">
+      {{ range .Templates }}
+       <option value="{{ .ID }}">{{ .Name }}</option>
+      {{ end }}
+     </select>
+     <input type="text" name="name" placeholder="list name">
+     <button>New from Template</button>
+    </form>
+   </li>
+   {{ end }}
    {{ range .Lists }}
-    <li style="margin: 0.7em 0">
-     <a href="/lists/{{ .ID }}">{{ .Name }}</a>
-     <span style="color: gray; font-size: 75%; margin-left: 0.2em;" title="{{ .TimeCreated.Format "2006-01-02 15:04:05" }}">{{ .TimeCreated.Format "2 Jan" }}</span>
-     <a style="padding-left: 0.5em; color: #ccc; text-decoration: none;" href="/lists/{{ .ID }}?delete=1" title="Delete List">✕</a>
+    <li>
+     <a href="{{ url "/lists/" .ID }}">{{ .Name }}</a>
+     {{ if .Template }}<span class="tag">template</span>{{ end }}
+     <span class="date" title="{{ .TimeCreated.Format "2006-01-02 15:04:05" }}">{{ .TimeCreated.Format "2 Jan" }}</span>
+     <a class="delete" href="{{ url "/lists/" .ID }}?delete=1" title="Delete List">✕</a>
     </li>
    {{ end }}
   </ul>
 {{ end }}
-  <div style="margin: 5em 0; border-top: 1px solid #ccc; text-align: center;">
-   <a style="color: gray; font-size: 75%" href="https://github.com/benhoyt/simplelists">About</a>
+  <div class="footer">
+{{ if .ShowAgenda }}
+   <a href="{{ url "/today" }}">Today</a>
+   <a href="{{ url "/upcoming" }}">Upcoming</a>
+{{ end }}
+   <a href="https://github.com/benhoyt/simplelists">About</a>
   </div>
  </body>
 </html>
@@ -54,55 +104,412 @@ var listTmpl = `<!DOCTYPE html>
 <html>
  <head>
   <meta name="viewport" content="width=device-width, initial-scale=1">
+  <link rel="stylesheet" href="{{ url "/static/style.css" }}">
   <title>{{ .List.Name }}</title>
  </head>
  <body>
   <h1>{{ .List.Name }}</h1>
 {{ if .ShowDelete }}
- <form style="margin-bottom: 2em" action="/delete-list" method="POST" enctype="application/x-www-form-urlencoded">
+ <form class="confirm" action="{{ url "/delete-list" }}" method="POST" enctype="application/x-www-form-urlencoded">
   <input type="hidden" name="csrf-token" value="{{ $.Token }}">
   <input type="hidden" name="list-id" value="{{ .List.ID }}">
-  <span style="color: red">Are you sure you want to delete this list?</span>
+  <span class="warning">Are you sure you want to delete this list?</span>
   <button>Yes, delete it!</button>
  </form>
 {{ end }}
-  <ul style="list-style-type: none; margin: 0; padding: 0;">
+{{ if .ShowDeleteDone }}
+ <form class="confirm" action="{{ url "/delete-done-items" }}" method="POST" enctype="application/x-www-form-urlencoded">
+  <input type="hidden" name="csrf-token" value="{{ $.Token }}">
+  <input type="hidden" name="list-id" value="{{ .List.ID }}">
+  <span class="warning">Are you sure you want to delete {{ if eq .DoneCount 1 }}the done item{{ else }}all {{ .DoneCount }} done items{{ end }}?</span>
+  <button>Yes, delete {{ if eq .DoneCount 1 }}it{{ else }}them{{ end }}!</button>
+ </form>
+{{ end }}
+{{ if .ShowUncheckAll }}
+ <form class="confirm" action="{{ url "/uncheck-all-items" }}" method="POST" enctype="application/x-www-form-urlencoded">
+  <input type="hidden" name="csrf-token" value="{{ $.Token }}">
+  <input type="hidden" name="list-id" value="{{ .List.ID }}">
+  <span class="warning">Are you sure you want to uncheck {{ if eq .UncheckCount 1 }}the done item{{ else }}all {{ .UncheckCount }} done items{{ end }}?</span>
+  <button>Yes, uncheck {{ if eq .UncheckCount 1 }}it{{ else }}them{{ end }}!</button>
+ </form>
+{{ end }}
+{{ with .CopyName }}
+ <form class="confirm" action="{{ url "/duplicate-list" }}" method="POST" enctype="application/x-www-form-urlencoded">
+  <input type="hidden" name="csrf-token" value="{{ $.Token }}">
+  <input type="hidden" name="list-id" value="{{ $.List.ID }}">
+  <label for="copy-name">Copy this list (with all items not done) as</label>
+  <input type="text" id="copy-name" name="name" value="{{ . }}" autofocus>
+  <button>Duplicate</button>
+ </form>
+{{ end }}
+{{ if .EditTemplate }}
+ <form class="confirm" action="{{ url "/set-template" }}" method="POST" enctype="application/x-www-form-urlencoded">
+  <input type="hidden" name="csrf-token" value="{{ $.Token }}">
+  <input type="hidden" name="list-id" value="{{ .List.ID }}">
+  {{ if .List.Template }}
+   <span>Remove this list from the “new from template” choices on the home page?</span>
+   <button>Yes, remove it</button>
+  {{ else }}
+   <input type="hidden" name="template" value="on">
+   <span>Add this list to the “new from template” choices on the home page?</span>
+   <button>Yes, use as template</button>
+  {{ end }}
+ </form>
+{{ end }}
+{{ with .EditDue }}
+ <form class="confirm" action="{{ url "/set-due" }}" method="POST" enctype="application/x-www-form-urlencoded">
+  <input type="hidden" name="csrf-token" value="{{ $.Token }}">
+  <input type="hidden" name="list-id" value="{{ $.List.ID }}">
+  <input type="hidden" name="item-id" value="{{ .ID }}">
+  <label for="due-date">Due date for “{{ .Description }}”</label>
+  <input type="date" id="due-date" name="due-date" value="{{ $.DueDate }}" autofocus>
+  <input type="time" name="due-time" value="{{ $.DueTime }}" title="Due time (optional)">
+  <button>Save</button>
+  <span class="aside">(leave the date empty for no due date)</span>
+ </form>
+{{ end }}
+{{ with .EditRepeat }}
+ <form class="confirm" action="{{ url "/set-repeat" }}" method="POST" enctype="application/x-www-form-urlencoded">
+  <input type="hidden" name="csrf-token" value="{{ $.Token }}">
+  <input type="hidden" name="list-id" value="{{ $.List.ID }}">
+  <input type="hidden" name="item-id" value="{{ .ID }}">
+  <span>Repeat “{{ .Description }}”</span>
+  <select name="repeat">
+   <option value="">never</option>
+   <option value="daily"{{ if eq $.RepeatFrequency "daily" }} selected{{ end }}>every day</option>
+   <option value="weekly"{{ if eq $.RepeatFrequency "weekly" }} selected{{ end }}>every week on</option>
+   <option value="monthly"{{ if eq $.RepeatFrequency "monthly" }} selected{{ end }}>every month</option>
+  </select>
+  {{ range $.RepeatWeekdays }}
+   <label><input type="checkbox" name="weekday" value="{{ .Value }}"{{ if .Checked }} checked{{ end }}>{{ .Value }}</label>
+  {{ end }}
+  <button>Save</button>
+ </form>
+{{ end }}
+{{ with .CompleteChildren }}
+ <form class="confirm" action="{{ url "/complete-children" }}" method="POST" enctype="application/x-www-form-urlencoded">
+  <input type="hidden" name="csrf-token" value="{{ $.Token }}">
+  <input type="hidden" name="list-id" value="{{ $.List.ID }}">
+  <input type="hidden" name="item-id" value="{{ .ID }}">
+  <span>“{{ .Description }}” still has {{ $.UndoneChildren }} sub-item{{ if ne $.UndoneChildren 1 }}s{{ end }} to do. Mark {{ if eq $.UndoneChildren 1 }}it{{ else }}them{{ end }} done too?</span>
+  <button>Yes, mark done</button>
+  <a class="aside" href="{{ url "/lists/" $.List.ID }}">No</a>
+ </form>
+{{ end }}
+{{ with .AddUnder }}
+ <form class="confirm" action="{{ url "/add-item" }}" method="POST" enctype="application/x-www-form-urlencoded">
+  <input type="hidden" name="csrf-token" value="{{ $.Token }}">
+  <input type="hidden" name="list-id" value="{{ $.List.ID }}">
+  <input type="hidden" name="parent-id" value="{{ .ID }}">
+  <label for="sub-item">Add sub-item under “{{ .Description }}”</label>
+  <input type="text" id="sub-item" name="description" placeholder="sub-item description" autofocus>
+  <button>Add</button>
+ </form>
+{{ end }}
+{{ if .ShowBulk }}
+ <form class="confirm" action="{{ url "/add-items" }}" method="POST" enctype="application/x-www-form-urlencoded">
+  <input type="hidden" name="csrf-token" value="{{ $.Token }}">
+  <input type="hidden" name="list-id" value="{{ .List.ID }}">
+  <label for="descriptions">Add several items, one per line</label>
+  <textarea id="descriptions" class="notes" name="descriptions" autofocus></textarea>
+  <input type="checkbox" id="strip-bullets" name="strip-bullets" value="on" checked>
+  <label for="strip-bullets">remove bullets like “-” and “[ ]”</label>
+  <button>Add Items</button>
+ </form>
+{{ end }}
+{{ with .MoveItem }}
+ <form class="confirm" action="{{ url "/move-item" }}" method="POST" enctype="application/x-www-form-urlencoded">
+  <input type="hidden" name="csrf-token" value="{{ $.Token }}">
+  <input type="hidden" name="list-id" value="{{ $.List.ID }}">
+  <input type="hidden" name="item-id" value="{{ .ID }}">
+  <label for="section">Move “{{ .Description }}” to</label>
+  <select id="section" name="section-id">
+   <option value="">top of list</option>
+   {{ range $.Headings }}
+    <option value="{{ .ID }}">{{ .Description }}</option>
+   {{ end }}
+  </select>
+  <button>Move</button>
+ </form>
+{{ end }}
+{{ with .EditNotes }}
+ <form class="confirm" action="{{ url "/set-notes" }}" method="POST" enctype="application/x-www-form-urlencoded">
+  <input type="hidden" name="csrf-token" value="{{ $.Token }}">
+  <input type="hidden" name="list-id" value="{{ $.List.ID }}">
+  <input type="hidden" name="item-id" value="{{ .ID }}">
+  <label for="notes">Notes for “{{ .Description }}”</label>
+  <textarea id="notes" class="notes" name="notes" autofocus>{{ .Notes }}</textarea>
+  <button>Save</button>
+ </form>
+{{ end }}
+  <ul class="items">
    {{ range .List.Items }}
-    <li style="margin: 0.7em 0">
-     <form style="display: inline;" action="/update-done" method="POST" enctype="application/x-www-form-urlencoded">
+   {{ if .Heading }}
+    <li class="heading">
+     <form class="inline" action="{{ url "/toggle-section" }}" method="POST" enctype="application/x-www-form-urlencoded">
+      <input type="hidden" name="csrf-token" value="{{ $.Token }}">
+      <input type="hidden" name="list-id" value="{{ $.List.ID }}">
+      <input type="hidden" name="item-id" value="{{ .ID }}">
+      {{ if .Collapsed }}
+       <button id="toggle-{{ .ID }}" class="toggle" title="Expand section">▸</button>
+      {{ else }}
+       <input type="hidden" name="collapsed" value="on">
+       <button id="toggle-{{ .ID }}" class="toggle" title="Collapse section">▾</button>
+      {{ end }}
+      <label for="toggle-{{ .ID }}">{{ .Description }}</label>
+      {{ with index $.HiddenCounts .ID }}
+       <span class="date">{{ . }} hidden</span>
+      {{ end }}
+     </form>
+     <form class="inline" action="{{ url "/delete-item" }}" method="POST" enctype="application/x-www-form-urlencoded">
+      <input type="hidden" name="csrf-token" value="{{ $.Token }}">
+      <input type="hidden" name="list-id" value="{{ $.List.ID }}">
+      <input type="hidden" name="item-id" value="{{ .ID }}">
+      <button class="delete" title="Delete Heading">✕</button>
+     </form>
+    </li>
+   {{ else }}
+    <li{{ with .Depth }} class="depth-{{ . }}"{{ end }}>
+     <form class="inline" action="{{ url "/update-done" }}" method="POST" enctype="application/x-www-form-urlencoded">
       <input type="hidden" name="csrf-token" value="{{ $.Token }}">
       <input type="hidden" name="list-id" value="{{ $.List.ID }}">
       <input type="hidden" name="item-id" value="{{ .ID }}">
       {{ if .Done }}
-       <button id="done-{{ .ID }}" style="width: 1.7em">✓</button>
-       <label for="done-{{ .ID }}"><del>{{ .Description }}</del></label>
+       <button id="done-{{ .ID }}" class="check">✓</button>
+       <label for="done-{{ .ID }}"><del>{{ tagLinks .Description }}</del></label>
       {{ else }}
        <input type="hidden" name="done" value="on">
-       <button id="done-{{ .ID }}" style="width: 1.7em">&nbsp;</button>
-       <label for="done-{{ .ID }}">{{ .Description }}</label>
+       <button id="done-{{ .ID }}" class="check">&nbsp;</button>
+       <label for="done-{{ .ID }}">{{ tagLinks .Description }}</label>
+      {{ end }}
+      {{ if not .TimeDue.IsZero }}
+       <span class="due {{ dueClass . }}" title="{{ .TimeDue.Format "2006-01-02 15:04" }}">due {{ if .DueAllDay }}{{ .TimeDue.Format "Mon 2 Jan" }}{{ else }}{{ .TimeDue.Format "Mon 2 Jan 15:04" }}{{ end }}</span>
       {{ end }}
      </form>
-     <form style="display: inline;" action="/delete-item" method="POST" enctype="application/x-www-form-urlencoded">
+     <form class="inline" action="{{ url "/delete-item" }}" method="POST" enctype="application/x-www-form-urlencoded">
       <input type="hidden" name="csrf-token" value="{{ $.Token }}">
       <input type="hidden" name="list-id" value="{{ $.List.ID }}">
       <input type="hidden" name="item-id" value="{{ .ID }}">
-      <button style="padding: 0 0.5em; border: none; background: none; color: #ccc" title="Delete Item">✕</button>
+      <button class="delete" title="Delete Item">✕</button>
      </form>
+     {{ if not .Done }}
+      <a class="tool" href="{{ url "/lists/" $.List.ID }}?due={{ .ID }}" title="Due date">📅</a>
+      <a class="tool" href="{{ url "/lists/" $.List.ID }}?repeat={{ .ID }}" title="Repeat">↻{{ with .Repeat }} {{ describeRepeat . }}{{ end }}</a>
+      {{ if lt .Depth $.MaxDepth }}
+       <a class="tool" href="{{ url "/lists/" $.List.ID }}?parent={{ .ID }}" title="Add sub-item">+</a>
+      {{ end }}
+     {{ end }}
+     {{ if .Notes }}
+      <details class="notes">
+       <summary title="Notes">🗒</summary>
+       <div class="notes">{{ linkify .Notes }}</div>
+       <a class="aside" href="{{ url "/lists/" $.List.ID }}?notes={{ .ID }}">Edit notes</a>
+      </details>
+     {{ else }}
+      <a class="tool" href="{{ url "/lists/" $.List.ID }}?notes={{ .ID }}" title="Add notes">✎</a>
+     {{ end }}
+     {{ if $.Headings }}
+      <a class="tool" href="{{ url "/lists/" $.List.ID }}?move={{ .ID }}" title="Move to section">⇅</a>
+     {{ end }}
     </li>
    {{ end }}
-   <li style="margin: 0.5em 0">
-    <form action="/add-item" method="POST" enctype="application/x-www-form-urlencoded">
+   {{ end }}
+   <li class="add">
+    <form action="{{ url "/add-item" }}" method="POST" enctype="application/x-www-form-urlencoded">
      <input type="hidden" name="csrf-token" value="{{ $.Token }}">
      <input type="hidden" name="list-id" value="{{ .List.ID }}">
-     <input type="text" name="description" placeholder="item description" autofocus>
-     <button style="margin-top: 1em" type="submit">Add</button>
+     <input type="text" name="description" placeholder="item description (or ## heading)" autofocus>
+     <input type="date" name="due-date" title="Due date (optional)">
+     <input type="time" name="due-time" title="Due time (optional)">
+     <button type="submit">Add</button>
     </form>
+    <a class="aside" href="{{ url "/lists/" .List.ID }}?bulk=1">paste several items</a>
    </li>
   </ul>
-  <div style="margin: 5em 0; border-top: 1px solid #ccc; text-align: center;">
-   <a style="color: gray; font-size: 75%; margin-right: 1em;" href="/">Home</a>
-   <a style="color: gray; font-size: 75%" href="https://github.com/benhoyt/simplelists">About</a>
+  <div class="hint">
+   {{ if .DoneCount }}
+    <a href="{{ url "/lists/" .List.ID }}?delete-done=1">Delete done items</a>
+   {{ end }}
+   {{ if .UncheckCount }}
+    <a href="{{ url "/lists/" .List.ID }}?uncheck-all=1">Uncheck all</a>
+   {{ end }}
+   <a href="{{ url "/lists/" .List.ID }}?duplicate=1">Duplicate list</a>
+   {{ if .ShowTemplate }}
+    <a href="{{ url "/lists/" .List.ID }}?template=1">{{ if .List.Template }}Stop using as template{{ else }}Use as template{{ end }}</a>
+   {{ end }}
+  </div>
+{{ if .HasDue }}
+  <div class="hint">
+   {{ if .SortByDue }}
+    <a href="{{ url "/lists/" .List.ID }}">Sort by order added</a>
+   {{ else }}
+    <a href="{{ url "/lists/" .List.ID }}?sort=due">Sort by due date</a>
+   {{ end }}
+  </div>
+{{ end }}
+  <div class="footer">
+   <a href="{{ url "/" }}">Home</a>
+   <a href="https://github.com/benhoyt/simplelists">About</a>
+  </div>
+ </body>
+</html>
+`
+
+var agendaTmpl = `<!DOCTYPE html>
+<html>
+ <head>
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <link rel="stylesheet" href="{{ url "/static/style.css" }}">
+  <title>{{ .Title }}</title>
+ </head>
+ <body>
+  <h1>{{ .Title }}</h1>
+{{ range .Groups }}
+ {{ with .Title }}
+  <h2>{{ . }}</h2>
+ {{ end }}
+  <ul class="items">
+   {{ range .Items }}
+    <li>
+     <form class="inline" action="{{ url "/update-done" }}" method="POST" enctype="application/x-www-form-urlencoded">
+      <input type="hidden" name="csrf-token" value="{{ $.Token }}">
+      <input type="hidden" name="list-id" value="{{ .ListID }}">
+      <input type="hidden" name="item-id" value="{{ .ID }}">
+      <input type="hidden" name="return" value="{{ $.Return }}">
+      <input type="hidden" name="done" value="on">
+      <button id="done-{{ .ID }}" class="check">&nbsp;</button>
+      <label for="done-{{ .ID }}">{{ tagLinks .Description }}</label>
+      {{ if not .TimeDue.IsZero }}
+       <span class="due {{ dueClass .Item }}" title="{{ .TimeDue.Format "2006-01-02 15:04" }}">due {{ if .DueAllDay }}{{ .TimeDue.Format "Mon 2 Jan" }}{{ else }}{{ .TimeDue.Format "Mon 2 Jan 15:04" }}{{ end }}</span>
+      {{ end }}
+     </form>
+     <a class="aside" href="{{ url "/lists/" .ListID }}">{{ .ListName }}</a>
+    </li>
+   {{ end }}
+  </ul>
+{{ else }}
+  <p class="hint">{{ .Empty }}</p>
+{{ end }}
+  <div class="footer">
+   <a href="{{ url "/" }}">Home</a>
+   <a href="{{ url "/today" }}">Today</a>
+   <a href="{{ url "/upcoming" }}">Upcoming</a>
+   <a href="https://github.com/benhoyt/simplelists">About</a>
+  </div>
+ </body>
+</html>
+`
+
+var sessionsTmpl = `<!DOCTYPE html>
+<html>
+ <head>
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <link rel="stylesheet" href="{{ url "/static/style.css" }}">
+  <title>Sessions</title>
+ </head>
+ <body>
+  <h1>Sessions</h1>
+  <ul class="items">
+   {{ range .SignIns }}
+    <li class="spaced">
+     <div>{{ if .UserAgent }}{{ .UserAgent }}{{ else }}unknown browser{{ end }}</div>
+     <div class="details">
+      {{ if .IP }}{{ .IP }} &middot; {{ end }}last seen <span title="{{ .TimeLastSeen.Format "2006-01-02 15:04:05" }}">{{ .TimeLastSeen.Format "2 Jan 15:04" }}</span>
+      &middot; signed in <span title="{{ .TimeCreated.Format "2006-01-02 15:04:05" }}">{{ .TimeCreated.Format "2 Jan" }}</span>
+     </div>
+     {{ if .Current }}
+      <span class="current">this browser</span>
+     {{ else }}
+      <form action="{{ url "/revoke-session" }}" method="POST" enctype="application/x-www-form-urlencoded">
+       <input type="hidden" name="csrf-token" value="{{ $.Token }}">
+       <input type="hidden" name="key" value="{{ .Key }}">
+       <button>Revoke</button>
+      </form>
+     {{ end }}
+    </li>
+   {{ end }}
+  </ul>
+  <form class="section" action="{{ url "/sign-out-everywhere" }}" method="POST" enctype="application/x-www-form-urlencoded">
+   <input type="hidden" name="csrf-token" value="{{ $.Token }}">
+   <button>Sign Out Everywhere</button>
+  </form>
+  <div class="footer">
+   <a href="{{ url "/" }}">Home</a>
+   <a href="https://github.com/benhoyt/simplelists">About</a>
   </div>
  </body>
 </html>
 `
+
+var redirectTmpl = `<!DOCTYPE html>
+<html>
+ <head>
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <link rel="stylesheet" href="{{ url "/static/style.css" }}">
+  <meta http-equiv="refresh" content="0; url={{ url .ReturnURL }}">
+  <title>Simple Lists</title>
+ </head>
+ <body>
+  <p>Signed in. <a href="{{ url .ReturnURL }}">Continue</a></p>
+ </body>
+</html>
+`
+
+var errorTmpl = `<!DOCTYPE html>
+<html>
+ <head>
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <link rel="stylesheet" href="{{ url "/static/style.css" }}">
+  <title>{{ .Title }}</title>
+ </head>
+ <body>
+  <h1>{{ .Title }}</h1>
+  <p>{{ .Message }}</p>
+  <div class="footer">
+   <a href="{{ url "/" }}">Home</a>
+  </div>
+ </body>
+</html>
+`
+
+// stylesheet is served at /static/style.css. All styles live here (rather
+// than in style attributes) so the Content-Security-Policy can forbid
+// inline styles.
+var stylesheet = `ul.items { list-style-type: none; margin: 0; padding: 0; }
+ul.items li { margin: 0.7em 0; }
+ul.items li.spaced { margin: 1em 0; }
+ul.items li.add { margin: 0.5em 0; }
+ul.items li.add button { margin-top: 1em; }
+form.block { margin: 1em 0; }
+form.section { margin: 2em 0; }
+form.confirm { margin-bottom: 2em; }
+form.inline { display: inline; }
+button.check { width: 1.7em; }
+button.delete { padding: 0 0.5em; border: none; background: none; color: #ccc; }
+a.delete { padding-left: 0.5em; color: #ccc; text-decoration: none; }
+a.aside, span.aside { color: gray; font-size: 75%; margin-left: 0.5em; }
+.date { color: gray; font-size: 75%; margin-left: 0.2em; }
+.details { color: gray; font-size: 75%; margin: 0.2em 0; }
+.hint { color: gray; font-size: 75%; margin: 0.5em 0; }
+.error { color: red; margin: 0.5em 0; }
+.warning { color: red; }
+.current { color: green; font-size: 75%; }
+.due { color: gray; font-size: 75%; margin-left: 0.5em; }
+.due.due-today { color: #c60; font-weight: bold; }
+.due.overdue { color: red; font-weight: bold; }
+ul.items li.heading { margin-top: 1.5em; font-weight: bold; }
+button.toggle { width: 1.7em; border: none; background: none; }
+ul.items li.depth-1 { margin-left: 2em; }
+ul.items li.depth-2 { margin-left: 4em; }
+ul.items li.depth-3 { margin-left: 6em; }
+ul.items li.depth-4 { margin-left: 8em; }
+ul.items li.depth-5 { margin-left: 10em; }
+a.tag { color: #369; text-decoration: none; }
+a.tool { color: #ccc; font-size: 75%; text-decoration: none; }
+details.notes { display: inline; }
+details.notes summary { display: inline; cursor: pointer; font-size: 75%; }
+div.notes { white-space: pre-wrap; color: #444; font-size: 90%; margin: 0.3em 0 0.3em 2.2em; }
+textarea.notes { display: block; width: 100%; max-width: 30em; height: 8em; margin: 0.5em 0; }
+.footer { margin: 5em 0; border-top: 1px solid #ccc; text-align: center; }
+.footer a { color: gray; font-size: 75%; margin: 0 0.5em; }
+`
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters (RFC 6238 defaults, which all authenticator apps support).
+const (
+	totpDigits     = 6
+	totpPeriod     = 30 * time.Second
+	totpSkew       = 1 // number of periods before and after now to accept
+	totpIssuer     = "Simple Lists"
+	totpSecretSize = 20
+
+	numRecoveryCodes = 10
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret generates a new random TOTP secret, encoded as base32.
+func GenerateTOTPSecret() string {
+	b := make([]byte, totpSecretSize)
+	_, err := rand.Read(b)
+	if err != nil { // should never fail
+		panic(err)
+	}
+	return totpEncoding.EncodeToString(b)
+}
+
+// ParseTOTPSecret decodes a base32 TOTP secret (spaces and case are ignored).
+func ParseTOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.Replace(secret, " ", "", -1))
+	key, err := totpEncoding.DecodeString(strings.TrimRight(secret, "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base32 TOTP secret: %v", err)
+	}
+	if len(key) < 16 {
+		return nil, fmt.Errorf("TOTP secret too short (%d bytes, must be at least 16)", len(key))
+	}
+	return key, nil
+}
+
+// TOTPURI returns the otpauth:// URI used to add the secret to an
+// authenticator app (usually shown as a QR code).
+func TOTPURI(secret, username string) string {
+	if username == "" {
+		username = "simplelists"
+	}
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", totpIssuer)
+	return "otpauth://totp/" + url.PathEscape(totpIssuer+":"+username) + "?" + params.Encode()
+}
+
+// hotp returns the RFC 4226 HMAC-based one-time password for the given key
+// and counter.
+func hotp(key []byte, counter uint64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
+
+// totpCounter returns the TOTP time step counter for time t.
+func totpCounter(t time.Time) uint64 {
+	return uint64(t.Unix()) / uint64(totpPeriod/time.Second)
+}
+
+// verifyTOTP checks code against the key at time now (allowing for clock
+// skew), returning the matching counter and true if it's valid.
+func verifyTOTP(key []byte, code string, now time.Time) (uint64, bool) {
+	counter := totpCounter(now)
+	for i := -totpSkew; i <= totpSkew; i++ {
+		c := counter + uint64(i)
+		if hmac.Equal([]byte(hotp(key, c)), []byte(code)) {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+// GenerateRecoveryCodes generates a set of random single-use recovery codes,
+// returning the codes (to show the user) and their hashes (for the config).
+func GenerateRecoveryCodes() (codes []string, hashes []string) {
+	for i := 0; i < numRecoveryCodes; i++ {
+		b := make([]byte, 10)
+		_, err := rand.Read(b)
+		if err != nil { // should never fail
+			panic(err)
+		}
+		s := strings.ToLower(totpEncoding.EncodeToString(b))
+		code := s[:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:16]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes
+}
+
+// hashRecoveryCode returns the hex SHA-256 hash of a normalized recovery
+// code. Recovery codes have plenty of entropy, so a fast hash is fine.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.Replace(code, "-", "", -1))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseRecoveryHashes parses a comma-separated list of recovery code hashes.
+func ParseRecoveryHashes(s string) ([]string, error) {
+	var hashes []string
+	for _, field := range strings.Split(s, ",") {
+		hash := strings.ToLower(strings.TrimSpace(field))
+		if hash == "" {
+			continue
+		}
+		b, err := hex.DecodeString(hash)
+		if err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("invalid recovery code hash %q", hash)
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTOTPVectors(t *testing.T) {
+	// Test vectors from RFC 6238 appendix B (SHA-1), truncated to 6 digits
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, test := range tests {
+		code := hotp(key, totpCounter(time.Unix(test.unix, 0)))
+		ensureString(t, code, test.code)
+	}
+
+	secret := totpEncoding.EncodeToString(key)
+	parsed, err := ParseTOTPSecret(strings.ToLower(secret[:8]) + " " + secret[8:])
+	if err != nil {
+		t.Fatalf("parsing secret: %v", err)
+	}
+	ensureString(t, string(parsed), string(key))
+
+	// Codes from adjacent periods are accepted to allow for clock skew
+	now := time.Unix(1111111111, 0)
+	for _, offset := range []time.Duration{-totpPeriod, 0, totpPeriod} {
+		_, ok := verifyTOTP(key, hotp(key, totpCounter(now.Add(offset))), now)
+		if !ok {
+			t.Errorf("code at offset %v not accepted", offset)
+		}
+	}
+	_, ok := verifyTOTP(key, hotp(key, totpCounter(now.Add(2*totpPeriod))), now)
+	if ok {
+		t.Errorf("code two periods ahead accepted")
+	}
+}
+
+func TestSignInTOTP(t *testing.T) {
+	hash, err := GeneratePasswordHash("password")
+	if err != nil {
+		t.Fatalf("generating password hash: %v", err)
+	}
+	secret := GenerateTOTPSecret()
+	key, err := ParseTOTPSecret(secret)
+	if err != nil {
+		t.Fatalf("parsing secret: %v", err)
+	}
+	codes, hashes := GenerateRecoveryCodes()
+	server, _ := newTestServer(t, nullLogger{}, Config{
+		Username:     "bob",
+		PassHash:     hash,
+		TOTPSecret:   secret,
+		TOTPRecovery: strings.Join(hashes, ","),
+	})
+	now := time.Date(2021, 9, 10, 12, 0, 0, 0, time.UTC)
+	server.now = func() time.Time { return now }
+
+	var jar http.CookieJar
+	newSession := func() {
+		jar, err = cookiejar.New(nil)
+		if err != nil {
+			t.Fatalf("creating cookie jar: %v", err)
+		}
+	}
+	homeForm := func() Form {
+		t.Helper()
+		recorder := serve(t, server, jar, "GET", "/", nil)
+		forms := parseForms(t, recorder.Body.String())
+		return forms[0]
+	}
+	post := func(path string, values ...string) string {
+		t.Helper()
+		form := url.Values{}
+		form.Set("csrf-token", homeForm().Inputs["csrf-token"])
+		form.Set("return-url", "/lists/abc")
+		for i := 0; i < len(values); i += 2 {
+			form.Set(values[i], values[i+1])
+		}
+		recorder := serve(t, server, jar, "POST", path, form)
+		ensureCode(t, recorder, http.StatusFound)
+		return recorder.Result().Header.Get("Location")
+	}
+	// Correct password shows TOTP form but doesn't sign in yet
+	newSession()
+	location := post("/sign-in", "username", "bob", "password", "password")
+	ensureString(t, location, "/?return-url=%2Flists%2Fabc")
+	ensureString(t, homeForm().Action, "/sign-in-totp")
+	recorder := serve(t, server, jar, "GET", "/lists/abc", nil)
+	ensureCode(t, recorder, http.StatusFound)
+
+	// Wrong code
+	location = post("/sign-in-totp", "code", "000000")
+	ensureString(t, location, "/?error=totp&return-url=%2Flists%2Fabc")
+
+	// Correct code signs in
+	code := hotp(key, totpCounter(now))
+	location = post("/sign-in-totp", "code", code[:3]+" "+code[3:])
+	ensureString(t, location, "/lists/abc")
+	ensureString(t, homeForm().Action, "/sign-out")
+
+	// Same code can't be used again
+	newSession()
+	post("/sign-in", "username", "bob", "password", "password")
+	location = post("/sign-in-totp", "code", code)
+	ensureString(t, location, "/?error=totp&return-url=%2Flists%2Fabc")
+
+	// Recovery code works, but only once
+	location = post("/sign-in-totp", "code", strings.ToUpper(codes[3]))
+	ensureString(t, location, "/lists/abc")
+	newSession()
+	post("/sign-in", "username", "bob", "password", "password")
+	location = post("/sign-in-totp", "code", codes[3])
+	ensureString(t, location, "/?error=totp&return-url=%2Flists%2Fabc")
+
+	// Pending sign-in expires
+	now = now.Add(pendingSignInExpiry + time.Second)
+	ensureString(t, homeForm().Action, "/sign-in")
+	location = post("/sign-in-totp", "code", hotp(key, totpCounter(now)))
+	ensureString(t, location, "/?return-url=%2Flists%2Fabc")
+}
+
+func TestSignInTOTPLockout(t *testing.T) {
+	hash, err := GeneratePasswordHash("password")
+	if err != nil {
+		t.Fatalf("generating password hash: %v", err)
+	}
+	server, _ := newTestServer(t, nullLogger{}, Config{
+		Username:   "bob",
+		PassHash:   hash,
+		TOTPSecret: GenerateTOTPSecret(),
+	})
+	now := time.Date(2021, 9, 10, 12, 0, 0, 0, time.UTC)
+	server.now = func() time.Time { return now }
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("creating cookie jar: %v", err)
+	}
+	post := func(path string, values ...string) string {
+		t.Helper()
+		recorder := serve(t, server, jar, "GET", "/", nil)
+		form := url.Values{}
+		form.Set("csrf-token", parseForms(t, recorder.Body.String())[0].Inputs["csrf-token"])
+		for i := 0; i < len(values); i += 2 {
+			form.Set(values[i], values[i+1])
+		}
+		recorder = serve(t, server, jar, "POST", path, form)
+		ensureCode(t, recorder, http.StatusFound)
+		return recorder.Result().Header.Get("Location")
+	}
+
+	for i := 0; i < signInFreeAttempts; i++ {
+		post("/sign-in", "username", "bob", "password", "password")
+		ensureString(t, post("/sign-in-totp", "code", "000000"), "/?error=totp&return-url=%2F")
+	}
+
+	// Re-entering the correct password doesn't reset the lockout, so it
+	// keeps doubling with each wrong code
+	lockout := signInBaseLockout
+	for round := 0; round < 3; round++ {
+		now = now.Add(lockout - time.Second)
+		ensureString(t, post("/sign-in", "username", "bob", "password", "password"),
+			"/?error=locked&return-url=%2F")
+		now = now.Add(2 * time.Second)
+		ensureString(t, post("/sign-in", "username", "bob", "password", "password"), "/?return-url=%2F")
+		ensureString(t, post("/sign-in-totp", "code", "000000"), "/?error=totp&return-url=%2F")
+		lockout *= 2
+	}
+}